@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	sentry "github.com/getsentry/sentry-go"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	log "github.com/sirupsen/logrus"
+)
+
+// Annotations through which rule authors can override parts of the event of
+// an alert.
+const (
+	annotationLevel       = "sentry_level"
+	annotationEnvironment = "sentry_environment"
+	annotationFingerprint = "sentry_fingerprint"
+	annotationProject     = "sentry_project"
+	annotationRelease     = "sentry_release"
+	annotationTags        = "sentry_tags"
+	annotationMessage     = "sentry_message"
+
+	maxEnvironmentLength = 64
+)
+
+// annotationError logs and counts an invalid override annotation, or part
+// of one, which is then left out.
+func annotationError(alert amtemplate.Alert, annotation string, err error) {
+	log.Warnf("Invalid %s annotation of alert %s: %s", annotation, alert.Labels["alertname"], err)
+	annotationErrors.WithLabelValues(annotation).Inc()
+}
+
+// annotationEnv returns the environment the alert overrides, if valid.
+func annotationEnv(alert amtemplate.Alert) (string, bool) {
+	env, ok := alert.Annotations[annotationEnvironment]
+	if !ok {
+		return "", false
+	}
+
+	switch {
+	case env == "":
+		annotationError(alert, annotationEnvironment, errors.New("empty environment"))
+	case len(env) > maxEnvironmentLength:
+		annotationError(alert, annotationEnvironment, fmt.Errorf("longer than %d characters", maxEnvironmentLength))
+	case strings.ContainsAny(env, "/\r\n"):
+		annotationError(alert, annotationEnvironment, errors.New("contains a slash or a newline"))
+	default:
+		return env, true
+	}
+	return "", false
+}
+
+// annotationDSN returns the DSN of the project the alert overrides, if it is
+// one of the configured projects.
+func annotationDSN(alert amtemplate.Alert, projects map[string]string) (string, bool) {
+	project, ok := alert.Annotations[annotationProject]
+	if !ok {
+		return "", false
+	}
+
+	dsn, ok := projects[project]
+	if !ok {
+		annotationError(alert, annotationProject, fmt.Errorf("unknown project %q", project))
+	}
+	return dsn, ok
+}
+
+// applyAnnotations overrides parts of the event with the annotations of the
+// alert. Invalid ones are left out.
+func applyAnnotations(event *sentry.Event, alert amtemplate.Alert) {
+	if s, ok := alert.Annotations[annotationLevel]; ok {
+		level, err := parseLevel(strings.ToLower(strings.TrimSpace(s)))
+		if err != nil {
+			annotationError(alert, annotationLevel, err)
+		} else {
+			event.Level = level
+		}
+	}
+
+	if s, ok := alert.Annotations[annotationFingerprint]; ok {
+		var fingerprint []string
+		for _, part := range strings.Split(s, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				fingerprint = append(fingerprint, part)
+			}
+		}
+		if len(fingerprint) == 0 {
+			annotationError(alert, annotationFingerprint, errors.New("empty fingerprint"))
+		} else {
+			event.Fingerprint = fingerprint
+		}
+	}
+
+	if release, ok := alert.Annotations[annotationRelease]; ok {
+		if release = strings.TrimSpace(release); release == "" || strings.ContainsAny(release, "/\r\n") {
+			annotationError(alert, annotationRelease, errors.New("empty or contains a slash or a newline"))
+		} else {
+			event.Release = release
+		}
+	}
+
+	if s, ok := alert.Annotations[annotationTags]; ok {
+		for _, pair := range strings.Split(s, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+				annotationError(alert, annotationTags, fmt.Errorf("expected key=value, got %q", pair))
+				continue
+			}
+			event.Tags[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	if message, ok := alert.Annotations[annotationMessage]; ok {
+		if message == "" {
+			annotationError(alert, annotationMessage, errors.New("empty message"))
+		} else {
+			event.Message = message
+		}
+	}
+}
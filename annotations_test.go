@@ -0,0 +1,218 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	sentry "github.com/getsentry/sentry-go"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// annotationErrorCount returns how many errors were counted for the
+// annotation so far.
+func annotationErrorCount(t *testing.T, annotation string) float64 {
+	t.Helper()
+
+	m := &dto.Metric{}
+	if err := annotationErrors.WithLabelValues(annotation).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestApplyAnnotations(t *testing.T) {
+	original := sentry.Event{
+		Level:       sentry.LevelError,
+		Message:     "message",
+		Release:     "release",
+		Fingerprint: []string{"{{ default }}"},
+		Tags:        map[string]string{"alertname": "HighLoad"},
+	}
+
+	tests := []struct {
+		name        string
+		annotations amtemplate.KV
+		want        func(e *sentry.Event)
+		// errors are the annotations whose error count goes up, and by how
+		// much
+		errors map[string]float64
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:        "level",
+			annotations: amtemplate.KV{annotationLevel: " Warning "},
+			want:        func(e *sentry.Event) { e.Level = sentry.LevelWarning },
+		},
+		{
+			name:        "unknown level",
+			annotations: amtemplate.KV{annotationLevel: "critical"},
+			errors:      map[string]float64{annotationLevel: 1},
+		},
+		{
+			name:        "fingerprint",
+			annotations: amtemplate.KV{annotationFingerprint: "{{ default }}, db ,, primary"},
+			want:        func(e *sentry.Event) { e.Fingerprint = []string{"{{ default }}", "db", "primary"} },
+		},
+		{
+			name:        "empty fingerprint",
+			annotations: amtemplate.KV{annotationFingerprint: " , "},
+			errors:      map[string]float64{annotationFingerprint: 1},
+		},
+		{
+			name:        "release",
+			annotations: amtemplate.KV{annotationRelease: " v1.2.3 "},
+			want:        func(e *sentry.Event) { e.Release = "v1.2.3" },
+		},
+		{
+			name:        "release with a slash",
+			annotations: amtemplate.KV{annotationRelease: "v1/2"},
+			errors:      map[string]float64{annotationRelease: 1},
+		},
+		{
+			name:        "empty release",
+			annotations: amtemplate.KV{annotationRelease: " "},
+			errors:      map[string]float64{annotationRelease: 1},
+		},
+		{
+			name:        "tags",
+			annotations: amtemplate.KV{annotationTags: "team=db, tier = backend,,url=http://x?a=b"},
+			want: func(e *sentry.Event) {
+				e.Tags["team"] = "db"
+				e.Tags["tier"] = "backend"
+				e.Tags["url"] = "http://x?a=b"
+			},
+		},
+		{
+			name:        "tags overriding labels",
+			annotations: amtemplate.KV{annotationTags: "alertname=Other"},
+			want:        func(e *sentry.Event) { e.Tags["alertname"] = "Other" },
+		},
+		{
+			name:        "invalid tags",
+			annotations: amtemplate.KV{annotationTags: "team=db, bad, =value"},
+			want:        func(e *sentry.Event) { e.Tags["team"] = "db" },
+			errors:      map[string]float64{annotationTags: 2},
+		},
+		{
+			name:        "message",
+			annotations: amtemplate.KV{annotationMessage: "Database overloaded"},
+			want:        func(e *sentry.Event) { e.Message = "Database overloaded" },
+		},
+		{
+			name:        "empty message",
+			annotations: amtemplate.KV{annotationMessage: ""},
+			errors:      map[string]float64{annotationMessage: 1},
+		},
+		{
+			name: "several",
+			annotations: amtemplate.KV{
+				annotationLevel:   "info",
+				annotationRelease: "a/b",
+				annotationMessage: "hi",
+			},
+			want: func(e *sentry.Event) {
+				e.Level = sentry.LevelInfo
+				e.Message = "hi"
+			},
+			errors: map[string]float64{annotationRelease: 1},
+		},
+	}
+
+	annotations := []string{annotationLevel, annotationFingerprint, annotationRelease, annotationTags, annotationMessage}
+	for _, tt := range tests {
+		before := map[string]float64{}
+		for _, a := range annotations {
+			before[a] = annotationErrorCount(t, a)
+		}
+
+		event := original
+		event.Fingerprint = append([]string(nil), original.Fingerprint...)
+		event.Tags = map[string]string{}
+		for k, v := range original.Tags {
+			event.Tags[k] = v
+		}
+		want := event
+		want.Tags = map[string]string{}
+		for k, v := range original.Tags {
+			want.Tags[k] = v
+		}
+		if tt.want != nil {
+			tt.want(&want)
+		}
+
+		applyAnnotations(&event, amtemplate.Alert{Labels: amtemplate.KV{"alertname": "HighLoad"}, Annotations: tt.annotations})
+
+		if !reflect.DeepEqual(event, want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, event, want)
+		}
+		for _, a := range annotations {
+			if got := annotationErrorCount(t, a) - before[a]; got != tt.errors[a] {
+				t.Errorf("%s: got %v %s errors, want %v", tt.name, got, a, tt.errors[a])
+			}
+		}
+	}
+}
+
+func TestAnnotationEnv(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations amtemplate.KV
+		env         string
+		ok          bool
+		errors      float64
+	}{
+		{"none", nil, "", false, 0},
+		{"valid", amtemplate.KV{annotationEnvironment: "staging"}, "staging", true, 0},
+		{"empty", amtemplate.KV{annotationEnvironment: ""}, "", false, 1},
+		{"too long", amtemplate.KV{annotationEnvironment: strings.Repeat("e", maxEnvironmentLength+1)}, "", false, 1},
+		{"longest", amtemplate.KV{annotationEnvironment: strings.Repeat("e", maxEnvironmentLength)}, strings.Repeat("e", maxEnvironmentLength), true, 0},
+		{"slash", amtemplate.KV{annotationEnvironment: "prod/eu"}, "", false, 1},
+		{"newline", amtemplate.KV{annotationEnvironment: "prod\n"}, "", false, 1},
+	}
+
+	for _, tt := range tests {
+		before := annotationErrorCount(t, annotationEnvironment)
+		env, ok := annotationEnv(amtemplate.Alert{Annotations: tt.annotations})
+		if env != tt.env || ok != tt.ok {
+			t.Errorf("%s: got %q %t, want %q %t", tt.name, env, ok, tt.env, tt.ok)
+		}
+		if got := annotationErrorCount(t, annotationEnvironment) - before; got != tt.errors {
+			t.Errorf("%s: got %v errors, want %v", tt.name, got, tt.errors)
+		}
+	}
+}
+
+func TestAnnotationDSN(t *testing.T) {
+	projects := map[string]string{"payments": "https://key@sentry.example.com/3"}
+
+	tests := []struct {
+		name        string
+		annotations amtemplate.KV
+		dsn         string
+		ok          bool
+		errors      float64
+	}{
+		{"none", nil, "", false, 0},
+		{"known project", amtemplate.KV{annotationProject: "payments"}, "https://key@sentry.example.com/3", true, 0},
+		{"unknown project", amtemplate.KV{annotationProject: "billing"}, "", false, 1},
+	}
+
+	for _, tt := range tests {
+		before := annotationErrorCount(t, annotationProject)
+		dsn, ok := annotationDSN(amtemplate.Alert{Annotations: tt.annotations}, projects)
+		if dsn != tt.dsn || ok != tt.ok {
+			t.Errorf("%s: got %q %t, want %q %t", tt.name, dsn, ok, tt.dsn, tt.ok)
+		}
+		if got := annotationErrorCount(t, annotationProject) - before; got != tt.errors {
+			t.Errorf("%s: got %v errors, want %v", tt.name, got, tt.errors)
+		}
+	}
+
+	if _, ok := annotationDSN(amtemplate.Alert{Annotations: amtemplate.KV{annotationProject: "payments"}}, nil); ok {
+		t.Error("got a DSN without projects configured")
+	}
+}
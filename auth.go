@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// signatureHeader carries the hex encoded HMAC-SHA256 of the webhook body,
+// optionally prefixed with "sha256="
+const signatureHeader = "X-Signature-SHA256"
+
+// webhookAuth checks that webhooks come from a trusted Alertmanager.
+type webhookAuth struct {
+	token      string
+	hmacSecret []byte
+}
+
+// verify tells why a webhook with the given body is not authenticated, if it
+// is not.
+func (a *webhookAuth) verify(r *http.Request, body []byte) error {
+	if a.token != "" {
+		auth := r.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			return errors.New("missing bearer token")
+		}
+		if subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(a.token)) != 1 {
+			return errors.New("invalid bearer token")
+		}
+	}
+
+	if len(a.hmacSecret) > 0 {
+		signature := r.Header.Get(signatureHeader)
+		if signature == "" {
+			return fmt.Errorf("missing %s header", signatureHeader)
+		}
+
+		sum, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+		if err != nil {
+			return fmt.Errorf("malformed %s header", signatureHeader)
+		}
+
+		mac := hmac.New(sha256.New, a.hmacSecret)
+		mac.Write(body)
+		if !hmac.Equal(sum, mac.Sum(nil)) {
+			return errors.New("invalid body signature")
+		}
+	}
+
+	return nil
+}
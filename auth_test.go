@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookAuthVerify(t *testing.T) {
+	const body = `{"version":"4"}`
+	signature := sign("secret", body)
+
+	tests := []struct {
+		name   string
+		auth   webhookAuth
+		header map[string]string
+		body   string
+		ok     bool
+	}{
+		{
+			name: "no authentication configured",
+			ok:   true,
+		},
+		{
+			name:   "bearer token",
+			auth:   webhookAuth{token: "token"},
+			header: map[string]string{"Authorization": "Bearer token"},
+			ok:     true,
+		},
+		{
+			name:   "lower case bearer scheme",
+			auth:   webhookAuth{token: "token"},
+			header: map[string]string{"Authorization": "bearer token"},
+			ok:     true,
+		},
+		{
+			name: "missing bearer token",
+			auth: webhookAuth{token: "token"},
+		},
+		{
+			name:   "wrong bearer token",
+			auth:   webhookAuth{token: "token"},
+			header: map[string]string{"Authorization": "Bearer other"},
+		},
+		{
+			name:   "bearer token prefix",
+			auth:   webhookAuth{token: "token"},
+			header: map[string]string{"Authorization": "Bearer tok"},
+		},
+		{
+			name:   "other scheme",
+			auth:   webhookAuth{token: "token"},
+			header: map[string]string{"Authorization": "Basic token"},
+		},
+		{
+			name:   "short header",
+			auth:   webhookAuth{token: "token"},
+			header: map[string]string{"Authorization": "Bear"},
+		},
+		{
+			name:   "signature",
+			auth:   webhookAuth{hmacSecret: []byte("secret")},
+			header: map[string]string{signatureHeader: signature},
+			ok:     true,
+		},
+		{
+			name:   "signature without prefix",
+			auth:   webhookAuth{hmacSecret: []byte("secret")},
+			header: map[string]string{signatureHeader: strings.TrimPrefix(signature, "sha256=")},
+			ok:     true,
+		},
+		{
+			name: "missing signature",
+			auth: webhookAuth{hmacSecret: []byte("secret")},
+		},
+		{
+			name:   "malformed signature",
+			auth:   webhookAuth{hmacSecret: []byte("secret")},
+			header: map[string]string{signatureHeader: "sha256=not-hex"},
+		},
+		{
+			name:   "signature with another secret",
+			auth:   webhookAuth{hmacSecret: []byte("secret")},
+			header: map[string]string{signatureHeader: sign("other", body)},
+		},
+		{
+			name:   "tampered body",
+			auth:   webhookAuth{hmacSecret: []byte("secret")},
+			header: map[string]string{signatureHeader: signature},
+			body:   `{"version":"4","alerts":[]}`,
+		},
+		{
+			name:   "token and signature",
+			auth:   webhookAuth{token: "token", hmacSecret: []byte("secret")},
+			header: map[string]string{"Authorization": "Bearer token", signatureHeader: signature},
+			ok:     true,
+		},
+		{
+			name:   "token without signature",
+			auth:   webhookAuth{token: "token", hmacSecret: []byte("secret")},
+			header: map[string]string{"Authorization": "Bearer token"},
+		},
+		{
+			name:   "signature without token",
+			auth:   webhookAuth{token: "token", hmacSecret: []byte("secret")},
+			header: map[string]string{signatureHeader: signature},
+		},
+	}
+
+	for _, tt := range tests {
+		b := body
+		if tt.body != "" {
+			b = tt.body
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		for name, value := range tt.header {
+			r.Header.Set(name, value)
+		}
+
+		err := tt.auth.verify(r, []byte(b))
+		if tt.ok && err != nil {
+			t.Errorf("%s: rejected: %s", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	sentry "github.com/getsentry/sentry-go"
+)
+
+const (
+	defaultClientCacheSize   = 100
+	defaultClientIdleTimeout = 1 * time.Hour
+	evictFlushTimeout        = 5 * time.Second
+)
+
+// clientKey identifies a cached client. The fields are kept apart so that
+// no DSN and environment pair can be mistaken for another.
+type clientKey struct {
+	dsn string
+	env string
+}
+
+// clientEntry is a cached client along with what is needed to evict it.
+type clientEntry struct {
+	key       clientKey
+	dsn       string
+	env       string
+	client    *sentry.Client
+	transport resultTransport
+	created   time.Time
+	lastUsed  time.Time
+}
+
+// clientCache holds a Sentry client per DSN and environment, shared by all
+// of the workers. It keeps at most maxSize clients, evicting the least
+// recently used ones, and evicts clients unused for longer than idleTimeout.
+type clientCache struct {
+	newTransport func() resultTransport
+	maxSize      int
+	idleTimeout  time.Duration
+
+	mu      sync.Mutex
+	clients map[clientKey]*list.Element
+	// front is the most recently used
+	lru *list.List
+}
+
+func newClientCache(newTransport func() resultTransport, maxSize int, idleTimeout time.Duration) *clientCache {
+	return &clientCache{
+		newTransport: newTransport,
+		maxSize:      maxSize,
+		idleTimeout:  idleTimeout,
+		clients:      map[clientKey]*list.Element{},
+		lru:          list.New(),
+	}
+}
+
+func (c *clientCache) get(dsn, env string) (*sentry.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.expire(now)
+
+	key := clientKey{dsn: dsn, env: env}
+	if elem := c.clients[key]; elem != nil {
+		entry := elem.Value.(*clientEntry)
+		entry.lastUsed = now
+		c.lru.MoveToFront(elem)
+		return entry.client, nil
+	}
+
+	transport := c.newTransport()
+	sentryOptions := sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: env,
+		Transport:   transport}
+	client, err := sentry.NewClient(sentryOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	c.clients[key] = c.lru.PushFront(&clientEntry{
+		key:       key,
+		dsn:       dsn,
+		env:       env,
+		client:    client,
+		transport: transport,
+		created:   now,
+		lastUsed:  now,
+	})
+	for c.maxSize > 0 && c.lru.Len() > c.maxSize {
+		c.evict(c.lru.Back(), "size")
+	}
+
+	sentryClientsCached.Set(float64(c.lru.Len()))
+	return client, nil
+}
+
+// expire evicts the clients which have been idle for too long.
+func (c *clientCache) expire(now time.Time) {
+	if c.idleTimeout <= 0 {
+		return
+	}
+
+	for elem := c.lru.Back(); elem != nil; elem = c.lru.Back() {
+		if now.Sub(elem.Value.(*clientEntry).lastUsed) < c.idleTimeout {
+			break
+		}
+		c.evict(elem, "idle")
+	}
+	sentryClientsCached.Set(float64(c.lru.Len()))
+}
+
+// evict drops a client from the cache, flushing and closing it. Workers
+// still holding the client can finish sending with it.
+func (c *clientCache) evict(elem *list.Element, reason string) {
+	entry := c.lru.Remove(elem).(*clientEntry)
+	delete(c.clients, entry.key)
+
+	entry.client.Flush(evictFlushTimeout)
+	entry.transport.close()
+	sentryClientsEvicted.WithLabelValues(reason).Inc()
+}
+
+// flush flushes every cached client, sharing the timeout between them.
+func (c *clientCache) flush(timeout time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	ok := true
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		if !elem.Value.(*clientEntry).client.Flush(time.Until(deadline)) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// cachedClient is how a cached client is shown on the debug endpoint, the
+// DSN being stripped of its key.
+type cachedClient struct {
+	Host        string    `json:"host"`
+	Project     string    `json:"project"`
+	Environment string    `json:"environment"`
+	Created     time.Time `json:"created"`
+	LastUsed    time.Time `json:"last_used"`
+}
+
+// handleDebug lists the cached clients, most recently used first.
+func (c *clientCache) handleDebug(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	c.expire(time.Now())
+
+	clients := []cachedClient{}
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*clientEntry)
+
+		var host string
+		if u, err := url.Parse(entry.dsn); err == nil {
+			host = u.Host
+		}
+		clients = append(clients, cachedClient{
+			Host:        host,
+			Project:     dsnProject(entry.dsn),
+			Environment: entry.env,
+			Created:     entry.created,
+			LastUsed:    entry.lastUsed,
+		})
+	}
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(clients)
+}
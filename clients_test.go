@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func newTestClientCache(maxSize int, idleTimeout time.Duration) *clientCache {
+	return newClientCache(func() resultTransport {
+		return &sinkTransport{sink: &sink{w: ioutil.Discard}}
+	}, maxSize, idleTimeout)
+}
+
+func TestClientCacheKeys(t *testing.T) {
+	c := newTestClientCache(10, 0)
+
+	// both would be "https://k@h/42prod" if the fields were concatenated
+	a, err := c.get("https://k@h/4", "2prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.get("https://k@h/42", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Fatal("different DSN and environment pairs share a client")
+	}
+	if dsn := b.Options().Dsn; dsn != "https://k@h/42" {
+		t.Errorf("got client for %s", dsn)
+	}
+
+	again, err := c.get("https://k@h/4", "2prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != a {
+		t.Error("client not reused")
+	}
+	if n := c.lru.Len(); n != 2 {
+		t.Errorf("got %d cached clients, want 2", n)
+	}
+}
+
+func TestClientCacheEviction(t *testing.T) {
+	c := newTestClientCache(2, 0)
+
+	a, _ := c.get("https://k@h/1", "")
+	c.get("https://k@h/2", "")
+	// 1 is now the most recently used, so 2 goes first
+	c.get("https://k@h/1", "")
+	c.get("https://k@h/3", "")
+
+	if n := c.lru.Len(); n != 2 {
+		t.Fatalf("got %d cached clients, want 2", n)
+	}
+	if _, ok := c.clients[clientKey{dsn: "https://k@h/2"}]; ok {
+		t.Error("least recently used client not evicted")
+	}
+	if got, _ := c.get("https://k@h/1", ""); got != a {
+		t.Error("recently used client evicted")
+	}
+}
+
+func TestClientCacheIdleTimeout(t *testing.T) {
+	c := newTestClientCache(10, time.Minute)
+
+	a, _ := c.get("https://k@h/1", "")
+	c.clients[clientKey{dsn: "https://k@h/1"}].Value.(*clientEntry).lastUsed = time.Now().Add(-2 * time.Minute)
+
+	b, _ := c.get("https://k@h/1", "")
+	if a == b {
+		t.Error("idle client not evicted")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// config is the layout of the YAML file passed via --config.
+type config struct {
+	Route  *routeConfig `yaml:"route"`
+	Levels *levelConfig `yaml:"levels"`
+	Labels *labelConfig `yaml:"labels"`
+	Proxy  *proxyConfig `yaml:"proxy"`
+	// Projects are the DSNs alerts can be sent to by name with the
+	// sentry_project annotation
+	Projects map[string]string `yaml:"projects"`
+}
+
+// proxyConfig restricts DSN proxying.
+type proxyConfig struct {
+	// AllowedProjects, when given, are the only projects webhooks may be
+	// proxied to
+	AllowedProjects []allowedProjectConfig `yaml:"allowed_projects"`
+}
+
+type allowedProjectConfig struct {
+	Project string `yaml:"project"`
+	// Keys limits the keys accepted for the project, empty accepting any
+	Keys []string `yaml:"keys"`
+}
+
+// routeConfig is a node of the routing tree. Unset fields are inherited
+// from the parent route, the root route inherits from the command line.
+type routeConfig struct {
+	Match    map[string]string `yaml:"match"`
+	MatchRE  map[string]string `yaml:"match_re"`
+	Matchers []string          `yaml:"matchers"`
+	Continue bool              `yaml:"continue"`
+
+	DSN                  string   `yaml:"dsn"`
+	Environment          string   `yaml:"environment"`
+	HTMLTemplates        *bool    `yaml:"html_templates"`
+	Template             string   `yaml:"template"`
+	TemplateFile         string   `yaml:"template_file"`
+	FingerprintTemplates []string `yaml:"fingerprint_templates"`
+
+	// Tags, Extra and Contexts are templates of event fields by name, added
+	// to those inherited from the parent route
+	Tags     map[string]string            `yaml:"tags"`
+	Extra    map[string]string            `yaml:"extra"`
+	Contexts map[string]map[string]string `yaml:"contexts"`
+
+	// Templates of event fields, inherited from the parent route one by one
+	Transaction string            `yaml:"transaction"`
+	Release     string            `yaml:"release"`
+	Dist        string            `yaml:"dist"`
+	ServerName  string            `yaml:"server_name"`
+	User        map[string]string `yaml:"user"`
+
+	// Exceptions has events carry a synthetic exception, rendered with the
+	// Exception templates
+	Exceptions      *bool  `yaml:"exceptions"`
+	ExceptionType   string `yaml:"exception_type"`
+	ExceptionValue  string `yaml:"exception_value"`
+	ExceptionModule string `yaml:"exception_module"`
+
+	// GroupAlerts sends a single event per notification instead of one per
+	// alert, its message rendered with GroupTemplate
+	GroupAlerts       *bool  `yaml:"group_alerts"`
+	GroupTemplate     string `yaml:"group_template"`
+	GroupTemplateFile string `yaml:"group_template_file"`
+
+	Routes []*routeConfig `yaml:"routes"`
+}
+
+// userFields are the keys of the user of an event, as in its JSON.
+var userFields = []string{"id", "username", "email", "ip_address"}
+
+// eventFields returns the event field templates of the route by field name,
+// the fields of the user being prefixed with "user.".
+func (cfg *routeConfig) eventFields() (map[string]string, error) {
+	fields := map[string]string{}
+	for name, tmpl := range map[string]string{
+		"transaction": cfg.Transaction,
+		"release":     cfg.Release,
+		"dist":        cfg.Dist,
+		"server_name": cfg.ServerName,
+	} {
+		if tmpl != "" {
+			fields[name] = tmpl
+		}
+	}
+
+	for name, tmpl := range cfg.User {
+		known := false
+		for _, field := range userFields {
+			if name == field {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown user field %s, must be one of %s", name, strings.Join(userFields, ","))
+		}
+		fields["user."+name] = tmpl
+	}
+
+	return fields, nil
+}
+
+func loadConfig(path string) (*config, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config{}
+	err = yaml.UnmarshalStrict(file, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Route == nil {
+		cfg.Route = &routeConfig{}
+	}
+
+	return cfg, nil
+}
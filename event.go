@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+
+	sentry "github.com/getsentry/sentry-go"
+)
+
+// eventBuilder turns routed alerts into Sentry events.
+type eventBuilder struct {
+	levels         *levelMapper
+	labels         *labelFilter
+	dumbTimestamps bool
+	// tagFingerprint adds the fingerprint tag the resolver looks issues up by
+	tagFingerprint bool
+}
+
+func (b *eventBuilder) build(req gatewayRequest) (*sentry.Event, error) {
+	alert, rt := req.alert, req.route
+	data := templateData{Alert: alert, Data: req.data, Alerts: req.alerts}
+
+	tmpl := rt.template
+	if req.alerts != nil {
+		tmpl = rt.groupTemplate
+	}
+
+	var buf bytes.Buffer
+
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		templateErrors.WithLabelValues("message").Inc()
+		return nil, err
+	}
+
+	event := sentry.NewEvent()
+	event.Message = buf.String()
+	event.Environment = req.env
+	event.Timestamp = getEventTimestamp(alert, b.dumbTimestamps)
+	event.Extra["starts_at"] = alert.StartsAt
+	event.Extra["ends_at"] = alert.EndsAt
+	event.Logger = "alertmanager"
+	tags, dropped := getEventTags(alert, b.labels)
+	event.Tags = tags
+	if len(dropped) > 0 {
+		event.Extra["dropped_labels"] = dropped
+	}
+	if req.alerts != nil {
+		event.Level = groupLevel(req.alerts, b.levels)
+		event.Fingerprint = groupFingerprint(req.data)
+		event.Breadcrumbs = alertBreadcrumbs(req.alerts, b.levels)
+		event.Extra["firing"] = len(data.Alerts.Firing())
+		event.Extra["resolved"] = len(data.Alerts.Resolved())
+	} else {
+		event.Level = getEventAlertLevel(alert, b.levels)
+		event.Fingerprint = getEventFingerprint(data, rt.fingerprintTemplates)
+	}
+	event.Contexts["alertmanager"] = req.data
+
+	for name, value := range executeTemplateMap("tag", rt.tagTemplates, data) {
+		event.Tags[name] = value
+	}
+	for name, value := range executeTemplateMap("extra", rt.extraTemplates, data) {
+		event.Extra[name] = value
+	}
+	for name, templates := range rt.contextTemplates {
+		fields := map[string]interface{}{}
+		for field, value := range executeTemplateMap("context", templates, data) {
+			fields[field] = value
+		}
+		if len(fields) > 0 {
+			event.Contexts[name] = fields
+		}
+	}
+
+	fields := executeTemplateMap("field", rt.fieldTemplates, data)
+	event.Transaction = fields["transaction"]
+	event.Release = fields["release"]
+	event.Dist = fields["dist"]
+	event.ServerName = fields["server_name"]
+	event.User = sentry.User{
+		ID:        fields["user.id"],
+		Username:  fields["user.username"],
+		Email:     fields["user.email"],
+		IPAddress: fields["user.ip_address"],
+	}
+
+	applyAnnotations(event, alert)
+	if rt.exceptions {
+		parts := executeTemplateMap("exception", rt.exceptionTemplates, data)
+		event.Exception = []sentry.Exception{
+			syntheticException(alert, parts["type"], parts["value"], parts["module"], event.Message),
+		}
+	}
+
+	tags, original := sanitizeTags(event.Tags)
+	event.Tags = tags
+	if len(original) > 0 {
+		event.Extra["original_tags"] = original
+	}
+	if b.tagFingerprint {
+		event.Tags[fingerprintTag] = alert.Fingerprint
+	}
+
+	return event, nil
+}
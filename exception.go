@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	sentry "github.com/getsentry/sentry-go"
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+// Templates of synthetic exceptions, for routes not giving their own.
+const (
+	defaultExceptionType  = "{{ .Labels.alertname }}"
+	defaultExceptionValue = "{{ .Annotations.summary }}"
+)
+
+// syntheticException makes an alert look like an error to Sentry, for issue
+// titles, search and default grouping to work on it. Its single frame stands
+// for the alerting rule: the module defaults to the Prometheus the alert
+// came from and the rule expression is shown as the frame's code. The value
+// defaults to the first line of the message.
+func syntheticException(alert amtemplate.Alert, typ, value, module, message string) sentry.Exception {
+	if typ == "" {
+		typ = "Alert"
+	}
+	if value == "" {
+		// the first line, like Sentry titles messages
+		value = strings.SplitN(strings.TrimSpace(message), "\n", 2)[0]
+	}
+
+	frame := sentry.Frame{
+		Function: typ,
+		Module:   module,
+		InApp:    true,
+	}
+	if u, err := url.Parse(alert.GeneratorURL); err == nil && u.Host != "" {
+		if frame.Module == "" {
+			frame.Module = u.Host
+		}
+		frame.AbsPath = alert.GeneratorURL
+		frame.ContextLine = u.Query().Get("g0.expr")
+	}
+	if frame.Module == "" {
+		frame.Module = "alertmanager"
+	}
+	frame.Filename = frame.Module
+
+	return sentry.Exception{
+		Type:       typ,
+		Value:      value,
+		Module:     frame.Module,
+		Stacktrace: &sentry.Stacktrace{Frames: []sentry.Frame{frame}},
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	sentry "github.com/getsentry/sentry-go"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// gateway holds what serving and rendering have in common: how alerts are
+// routed and how events are built from them.
+type gateway struct {
+	defaultDSN string
+	defaultEnv string
+	envLabel   string
+
+	root    *route
+	builder *eventBuilder
+	// allowlist restricts proxied DSNs, nil allowing all of them
+	allowlist proxyAllowlist
+	// projects are the DSNs the sentry_project annotation can pick
+	projects map[string]string
+}
+
+func newGateway(cmd *cobra.Command) (*gateway, error) {
+	defaultDSN, err := cmd.Flags().GetString("dsn")
+	if err != nil {
+		return nil, err
+	}
+	if defaultDSN == "" {
+		defaultDSN = os.Getenv("SENTRY_DSN")
+	}
+
+	defaultEnv, err := cmd.Flags().GetString("environment")
+	if err != nil {
+		return nil, err
+	}
+	if defaultEnv == "" {
+		defaultEnv = os.Getenv("SENTRY_ENVIRONMENT")
+	}
+
+	envLabel, err := cmd.Flags().GetString("environment-label")
+	if err != nil {
+		return nil, err
+	}
+	if envLabel == "" {
+		envLabel = os.Getenv("SENTRY_ENVIRONMENT_LABEL")
+	}
+	if envLabel != "" {
+		log.Infof("Using alert label '%s' to overwrite sentry environment", envLabel)
+	}
+
+	tmplPath, err := cmd.Flags().GetString("template")
+	if err != nil {
+		return nil, err
+	}
+
+	var tmpl string
+	if tmplPath != "" {
+		file, err := ioutil.ReadFile(tmplPath)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl = string(file)
+	} else if envTmpl := os.Getenv("SENTRY_GATEWAY_TEMPLATE"); envTmpl != "" {
+		tmpl = envTmpl
+	} else {
+		tmpl = defaultTemplate
+	}
+
+	htmlTemplates, err := cmd.Flags().GetBool("html-templates")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("html-templates") {
+		if envHT, err := strconv.ParseBool(os.Getenv("SENTRY_GATEWAY_HTML_TEMPLATES")); err == nil {
+			htmlTemplates = envHT
+		}
+	}
+
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return nil, err
+	}
+	if configPath == "" {
+		configPath = os.Getenv("SENTRY_GATEWAY_CONFIG")
+	}
+
+	fingerprintTemplates, err := cmd.Flags().GetStringArray("fingerprint-templates")
+	if err != nil {
+		return nil, err
+	}
+	if len(fingerprintTemplates) == 0 {
+		fingerprintTemplates = strings.Split(os.Getenv("SENTRY_GATEWAY_FINGERPRINT_TEMPLATES"), ",")
+	}
+
+	exceptions, err := cmd.Flags().GetBool("exceptions")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("exceptions") {
+		if envEx, err := strconv.ParseBool(os.Getenv("SENTRY_GATEWAY_EXCEPTIONS")); err == nil {
+			exceptions = envEx
+		}
+	}
+
+	root := &route{
+		id:   "root",
+		html: htmlTemplates,
+		sources: templateSources{
+			template:    tmpl,
+			fingerprint: fingerprintTemplates,
+			exception: map[string]string{
+				"type":  defaultExceptionType,
+				"value": defaultExceptionValue,
+			},
+			group: defaultGroupTemplate,
+		},
+		exceptions: exceptions,
+	}
+	if err := root.compileTemplates(); err != nil {
+		return nil, err
+	}
+	levelCfg := defaultLevelConfig
+	var allowlist proxyAllowlist
+	var projects map[string]string
+	var labels *labelFilter
+	if configPath != "" {
+		log.Infof("Loading configuration from %s", configPath)
+
+		cfg, err := loadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+
+		root, err = newRoute(cfg.Route, root, "root")
+		if err != nil {
+			return nil, err
+		}
+
+		// like --dsn and --environment, which they take the place of, they
+		// give way to DSN proxying
+		if cfg.Route.DSN != "" {
+			defaultDSN = cfg.Route.DSN
+		}
+		if cfg.Route.Environment != "" {
+			defaultEnv = cfg.Route.Environment
+		}
+
+		if cfg.Levels != nil {
+			levelCfg = cfg.Levels.withDefaults()
+		}
+
+		if cfg.Proxy != nil && cfg.Proxy.AllowedProjects != nil {
+			allowlist, err = newProxyAllowlist(cfg.Proxy.AllowedProjects)
+			if err != nil {
+				return nil, err
+			}
+			log.Infof("Only proxying webhooks to %d allowed projects", len(allowlist))
+		}
+
+		for name, dsn := range cfg.Projects {
+			_, err := sentry.NewDsn(dsn)
+			if err != nil {
+				return nil, fmt.Errorf("project %s: %s", name, err)
+			}
+		}
+		projects = cfg.Projects
+
+		if cfg.Labels != nil {
+			labels, err = newLabelFilter(*cfg.Labels)
+			if err != nil {
+				return nil, fmt.Errorf("labels: %s", err)
+			}
+		}
+	}
+
+	levels, err := newLevelMapper(levelCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dumbTimestamps, err := cmd.Flags().GetBool("dumb-timestamps")
+	if err != nil {
+		return nil, err
+	}
+	if !cmd.Flags().Changed("dumb-timestamps") {
+		if envDT, err := strconv.ParseBool(os.Getenv("SENTRY_GATEWAY_DUMB_TIMESTAMPS")); err == nil {
+			dumbTimestamps = envDT
+		}
+	}
+
+	return &gateway{
+		defaultDSN: defaultDSN,
+		defaultEnv: defaultEnv,
+		envLabel:   envLabel,
+		root:       root,
+		allowlist:  allowlist,
+		projects:   projects,
+		builder: &eventBuilder{
+			levels:         levels,
+			labels:         labels,
+			dumbTimestamps: dumbTimestamps,
+		},
+	}, nil
+}
+
+// requests routes the alerts of a webhook, dsn and env being the ones the
+// webhook was addressed to. Alerts of grouping routes going to the same DSN
+// and environment make a single request.
+func (g *gateway) requests(msg *webhookMessage, dsn, env string) []gatewayRequest {
+	data := msg.groupData()
+
+	var reqs []gatewayRequest
+	groups := map[string]int{}
+	for _, alert := range msg.Alerts {
+		annotatedDSN, hasDSN := annotationDSN(alert, g.projects)
+		annotatedEnv, hasEnv := annotationEnv(alert)
+
+		for _, rt := range g.root.match(alert.Labels) {
+			alert_dsn := dsn
+			if rt.dsn != "" {
+				alert_dsn = rt.dsn
+			}
+			if hasDSN {
+				alert_dsn = annotatedDSN
+			}
+
+			alert_env := env
+			if rt.env != "" {
+				alert_env = rt.env
+			}
+			if g.envLabel != "" {
+				e := getSentryEnvironmentFromAlert(alert, g.envLabel)
+				if e != "" {
+					alert_env = e
+					log.Infof("Extracted sentry env: %s from alert: %s", alert_env, alert.Labels["alertname"])
+				}
+			}
+			if hasEnv {
+				alert_env = annotatedEnv
+			}
+
+			log.Debugf("Alert %s matched route %s", alert.Labels["alertname"], rt.id)
+
+			if rt.group {
+				key := rt.id + "\x00" + alert_dsn + "\x00" + alert_env
+				if i, ok := groups[key]; ok {
+					reqs[i].alerts = append(reqs[i].alerts, alert)
+					continue
+				}
+				groups[key] = len(reqs)
+				reqs = append(reqs, gatewayRequest{
+					dsn:    alert_dsn,
+					env:    alert_env,
+					alerts: []amtemplate.Alert{alert},
+					data:   data,
+					route:  rt,
+				})
+				continue
+			}
+
+			reqs = append(reqs, gatewayRequest{
+				dsn:   alert_dsn,
+				env:   alert_env,
+				alert: alert,
+				data:  data,
+				route: rt,
+			})
+		}
+	}
+
+	for i := range reqs {
+		if reqs[i].alerts != nil {
+			reqs[i].alert = groupAlert(reqs[i].alerts, data)
+		}
+	}
+	return reqs
+}
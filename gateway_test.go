@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+// newTestGateway routes alerts with the given root route configuration.
+func newTestGateway(t *testing.T, cfg *routeConfig) *gateway {
+	t.Helper()
+
+	root := &route{id: "root", sources: templateSources{group: defaultGroupTemplate}}
+	if err := root.compileTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	root, err := newRoute(cfg, root, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &gateway{root: root}
+}
+
+func testWebhook(alerts ...amtemplate.Alert) *webhookMessage {
+	return &webhookMessage{Data: amtemplate.Data{Receiver: "team", Status: "firing", Alerts: alerts}}
+}
+
+func TestRequestsDSN(t *testing.T) {
+	const (
+		rootDSN  = "https://key@sentry.example.com/1"
+		routeDSN = "https://key@sentry.example.com/2"
+		proxied  = "https://key@sentry.example.com/42"
+	)
+
+	g := newTestGateway(t, &routeConfig{
+		DSN:         rootDSN,
+		Environment: "root-env",
+		Routes: []*routeConfig{
+			{Match: map[string]string{"team": "db"}, DSN: routeDSN, Environment: "db-env"},
+			{Match: map[string]string{"team": "web"}},
+		},
+	})
+	if g.root.dsn != "" || g.root.env != "" {
+		t.Errorf("root route kept dsn %q and env %q, defaults are for newGateway", g.root.dsn, g.root.env)
+	}
+
+	tests := []struct {
+		name    string
+		team    string
+		dsn     string
+		env     string
+		wantDSN string
+		wantEnv string
+	}{
+		{"root yields to proxying", "other", proxied, "path-env", proxied, "path-env"},
+		{"child of root yields to proxying", "web", proxied, "path-env", proxied, "path-env"},
+		{"route overrides proxying", "db", proxied, "path-env", routeDSN, "db-env"},
+		{"route overrides default", "db", rootDSN, "root-env", routeDSN, "db-env"},
+	}
+
+	for _, tt := range tests {
+		reqs := g.requests(testWebhook(amtemplate.Alert{Labels: amtemplate.KV{"team": tt.team}}), tt.dsn, tt.env)
+		if len(reqs) != 1 {
+			t.Fatalf("%s: got %d requests, want 1", tt.name, len(reqs))
+		}
+		if reqs[0].dsn != tt.wantDSN || reqs[0].env != tt.wantEnv {
+			t.Errorf("%s: got %s %s, want %s %s", tt.name, reqs[0].dsn, reqs[0].env, tt.wantDSN, tt.wantEnv)
+		}
+	}
+}
+
+func TestRequestsGrouping(t *testing.T) {
+	group := true
+	g := newTestGateway(t, &routeConfig{
+		Routes: []*routeConfig{
+			{Match: map[string]string{"team": "db"}, GroupAlerts: &group, Continue: true},
+			{Match: map[string]string{"team": "db"}},
+		},
+	})
+
+	alerts := []amtemplate.Alert{
+		{Status: "firing", Labels: amtemplate.KV{"alertname": "HighLoad", "team": "db", "instance": "db-1"}},
+		{Status: "firing", Labels: amtemplate.KV{"alertname": "HighLoad", "team": "web", "instance": "web-1"}},
+		{Status: "resolved", Labels: amtemplate.KV{"alertname": "HighLoad", "team": "db", "instance": "db-2"}},
+	}
+	msg := testWebhook(alerts...)
+	msg.CommonLabels = amtemplate.KV{"alertname": "HighLoad"}
+
+	reqs := g.requests(msg, "https://key@sentry.example.com/1", "")
+
+	var grouped []gatewayRequest
+	perAlert := map[string]int{}
+	for _, req := range reqs {
+		if req.alerts != nil {
+			grouped = append(grouped, req)
+		} else {
+			perAlert[req.route.id]++
+		}
+	}
+
+	if len(grouped) != 1 {
+		t.Fatalf("got %d grouped requests, want 1", len(grouped))
+	}
+	req := grouped[0]
+	if req.route.id != "root/0" || len(req.alerts) != 2 {
+		t.Errorf("got %d alerts grouped on route %s, want 2 on root/0", len(req.alerts), req.route.id)
+	}
+	if req.alert.Status != "firing" {
+		t.Errorf("got group status %s", req.alert.Status)
+	}
+	if want := (amtemplate.KV{"alertname": "HighLoad", "team": "db"}); !reflect.DeepEqual(req.alert.Labels, want) {
+		t.Errorf("got group labels %v, want %v", req.alert.Labels, want)
+	}
+
+	// continue has the db alerts also sent one by one
+	if want := map[string]int{"root/1": 2, "root": 1}; !reflect.DeepEqual(perAlert, want) {
+		t.Errorf("got per alert requests %v, want %v", perAlert, want)
+	}
+}
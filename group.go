@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	sentry "github.com/getsentry/sentry-go"
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+// defaultGroupTemplate is the message template of events for whole
+// notifications, .Labels being the labels common to the alerts.
+const defaultGroupTemplate = "{{ range .Data.GroupLabels.SortedPairs }}{{ .Name }}={{ .Value }} {{ end }}" +
+	"({{ len .Alerts.Firing }} firing, {{ len .Alerts.Resolved }} resolved)"
+
+var levelSeverity = map[sentry.Level]int{
+	sentry.LevelDebug:   0,
+	sentry.LevelInfo:    1,
+	sentry.LevelWarning: 2,
+	sentry.LevelError:   3,
+	sentry.LevelFatal:   4,
+}
+
+// groupAlert stands in for the alerts of a notification sent as a single
+// event, so that the rest of the gateway can handle it like any alert. It
+// has the labels and annotations common to the alerts, is resolved once all
+// of them are, and its fingerprint identifies the group to the resolver.
+func groupAlert(alerts []amtemplate.Alert, data *groupData) amtemplate.Alert {
+	group := amtemplate.Alert{
+		Status:      "resolved",
+		Labels:      commonKV(alerts, func(a amtemplate.Alert) amtemplate.KV { return a.Labels }),
+		Annotations: commonKV(alerts, func(a amtemplate.Alert) amtemplate.KV { return a.Annotations }),
+		Fingerprint: groupFingerprintHash(data),
+	}
+
+	for i, alert := range alerts {
+		if alert.Status != "resolved" {
+			group.Status = "firing"
+		}
+		if i == 0 || alert.StartsAt.Before(group.StartsAt) {
+			group.StartsAt = alert.StartsAt
+		}
+		if alert.EndsAt.After(group.EndsAt) {
+			group.EndsAt = alert.EndsAt
+		}
+	}
+	if group.Status == "firing" {
+		group.EndsAt = time.Time{}
+	}
+
+	return group
+}
+
+// commonKV returns the pairs shared by all of the alerts. A route may group
+// only some alerts of a notification, so those common to the whole of it
+// may not be all there are.
+func commonKV(alerts []amtemplate.Alert, kv func(amtemplate.Alert) amtemplate.KV) amtemplate.KV {
+	common := amtemplate.KV{}
+	if len(alerts) == 0 {
+		return common
+	}
+
+	for name, value := range kv(alerts[0]) {
+		common[name] = value
+	}
+	for _, alert := range alerts[1:] {
+		pairs := kv(alert)
+		for name, value := range common {
+			if v, ok := pairs[name]; !ok || v != value {
+				delete(common, name)
+			}
+		}
+	}
+	return common
+}
+
+// groupFingerprint is the Sentry fingerprint of events for whole
+// notifications, made of the group labels or the receiver when there are
+// none.
+func groupFingerprint(data *groupData) []string {
+	var fingerprint []string
+	for _, pair := range data.GroupLabels.SortedPairs() {
+		fingerprint = append(fingerprint, pair.Name+"="+pair.Value)
+	}
+	if len(fingerprint) == 0 {
+		fingerprint = []string{"receiver=" + data.Receiver}
+	}
+	return fingerprint
+}
+
+func groupFingerprintHash(data *groupData) string {
+	h := fnv.New64a()
+	for _, part := range groupFingerprint(data) {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// groupLevel is the most severe level of the firing alerts, or of all of
+// them once they are resolved.
+func groupLevel(alerts amtemplate.Alerts, levels *levelMapper) sentry.Level {
+	if firing := alerts.Firing(); len(firing) > 0 {
+		alerts = firing
+	}
+
+	var level sentry.Level
+	for i, alert := range alerts {
+		l := getEventAlertLevel(alert, levels)
+		if i == 0 || levelSeverity[l] > levelSeverity[level] {
+			level = l
+		}
+	}
+	return level
+}
+
+// alertBreadcrumbs lists the alerts of a notification as breadcrumbs.
+func alertBreadcrumbs(alerts []amtemplate.Alert, levels *levelMapper) []*sentry.Breadcrumb {
+	var breadcrumbs []*sentry.Breadcrumb
+	for _, alert := range alerts {
+		timestamp := alert.StartsAt
+		if alert.Status == "resolved" {
+			timestamp = alert.EndsAt
+		}
+
+		breadcrumbs = append(breadcrumbs, &sentry.Breadcrumb{
+			Type:     "default",
+			Category: "alert",
+			Message:  fmt.Sprintf("%s %s", alert.Labels["alertname"], alert.Status),
+			Data: map[string]interface{}{
+				"status":      alert.Status,
+				"fingerprint": alert.Fingerprint,
+				"labels":      alert.Labels,
+			},
+			Level:     getEventAlertLevel(alert, levels),
+			Timestamp: timestamp,
+		})
+	}
+	return breadcrumbs
+}
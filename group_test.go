@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	sentry "github.com/getsentry/sentry-go"
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+func TestGroupAlert(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := &groupData{
+		Receiver:     "team",
+		GroupLabels:  amtemplate.KV{"alertname": "HighLoad"},
+		CommonLabels: amtemplate.KV{"alertname": "HighLoad"},
+	}
+
+	firing := amtemplate.Alert{
+		Status:      "firing",
+		Labels:      amtemplate.KV{"alertname": "HighLoad", "team": "db", "instance": "db-1"},
+		Annotations: amtemplate.KV{"summary": "load high", "runbook": "r1"},
+		StartsAt:    t0.Add(time.Hour),
+	}
+	resolved := amtemplate.Alert{
+		Status:      "resolved",
+		Labels:      amtemplate.KV{"alertname": "HighLoad", "team": "db", "instance": "db-2"},
+		Annotations: amtemplate.KV{"summary": "load high", "runbook": "r2"},
+		StartsAt:    t0,
+		EndsAt:      t0.Add(2 * time.Hour),
+	}
+	resolvedLater := amtemplate.Alert{
+		Status:   "resolved",
+		Labels:   amtemplate.KV{"alertname": "HighLoad", "team": "db"},
+		StartsAt: t0.Add(30 * time.Minute),
+		EndsAt:   t0.Add(3 * time.Hour),
+	}
+
+	group := groupAlert([]amtemplate.Alert{firing, resolved}, data)
+	if group.Status != "firing" {
+		t.Errorf("got status %s with a firing alert", group.Status)
+	}
+	if !group.StartsAt.Equal(t0) {
+		t.Errorf("got StartsAt %s, want the earliest", group.StartsAt)
+	}
+	if !group.EndsAt.IsZero() {
+		t.Errorf("got EndsAt %s while firing", group.EndsAt)
+	}
+	// common to the grouped alerts, even though the notification had
+	// fewer common labels
+	if want := (amtemplate.KV{"alertname": "HighLoad", "team": "db"}); !reflect.DeepEqual(group.Labels, want) {
+		t.Errorf("got labels %v, want %v", group.Labels, want)
+	}
+	if want := (amtemplate.KV{"summary": "load high"}); !reflect.DeepEqual(group.Annotations, want) {
+		t.Errorf("got annotations %v, want %v", group.Annotations, want)
+	}
+	if group.Fingerprint != groupFingerprintHash(data) {
+		t.Errorf("got fingerprint %s", group.Fingerprint)
+	}
+
+	group = groupAlert([]amtemplate.Alert{resolved, resolvedLater}, data)
+	if group.Status != "resolved" {
+		t.Errorf("got status %s with all alerts resolved", group.Status)
+	}
+	if !group.StartsAt.Equal(t0) || !group.EndsAt.Equal(t0.Add(3*time.Hour)) {
+		t.Errorf("got %s to %s, want the earliest start and latest end", group.StartsAt, group.EndsAt)
+	}
+	if len(group.Annotations) != 0 {
+		t.Errorf("got annotations %v, want none in common", group.Annotations)
+	}
+}
+
+func TestGroupFingerprint(t *testing.T) {
+	data := &groupData{Receiver: "team", GroupLabels: amtemplate.KV{"team": "db", "alertname": "HighLoad"}}
+	if got, want := groupFingerprint(data), []string{"alertname=HighLoad", "team=db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	other := &groupData{Receiver: "team", GroupLabels: amtemplate.KV{"team": "web", "alertname": "HighLoad"}}
+	if groupFingerprintHash(data) == groupFingerprintHash(other) {
+		t.Error("different groups share a fingerprint")
+	}
+
+	none := &groupData{Receiver: "team"}
+	if got, want := groupFingerprint(none), []string{"receiver=team"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestGroupLevel(t *testing.T) {
+	levels, err := newLevelMapper(defaultLevelConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert := func(status, severity string) amtemplate.Alert {
+		return amtemplate.Alert{Status: status, Labels: amtemplate.KV{"severity": severity}}
+	}
+
+	tests := []struct {
+		name   string
+		alerts amtemplate.Alerts
+		want   sentry.Level
+	}{
+		{"most severe", amtemplate.Alerts{alert("firing", "warning"), alert("firing", "critical"), alert("firing", "info")}, sentry.LevelFatal},
+		{"firing only", amtemplate.Alerts{alert("resolved", "critical"), alert("firing", "warning")}, sentry.LevelWarning},
+		{"all resolved", amtemplate.Alerts{alert("resolved", "info"), alert("resolved", "error")}, sentry.LevelError},
+	}
+	for _, tt := range tests {
+		if got := groupLevel(tt.alerts, levels); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
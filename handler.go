@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// webhookHandler accepts Alertmanager webhooks, queueing their alerts for
+// delivery.
+type webhookHandler struct {
+	g       *gateway
+	q       *queue
+	sp      *spool
+	auth    *webhookAuth
+	maxSize int64
+
+	// proxyURL, when set, has webhooks to /<project_id>[/<environment>]
+	// sent to that project of that Sentry, with the key from keySource
+	proxyURL  *url.URL
+	keySource string
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	webhooksReceived.Inc()
+
+	if r.Method != http.MethodPost {
+		webhooksRejected.WithLabelValues("method_not_allowed").Inc()
+		w.Header().Set("Allow", http.MethodPost)
+		webhookError(w, http.StatusMethodNotAllowed, "webhooks must be POSTed")
+		return
+	}
+
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, h.maxSize+1))
+	if err != nil {
+		log.Errorf("Could not read webhook: %s", err)
+		webhookError(w, http.StatusBadRequest, "could not read webhook")
+		return
+	}
+	if int64(len(body)) > h.maxSize {
+		log.Warnf("Rejecting webhook from %s larger than %d bytes", r.RemoteAddr, h.maxSize)
+		webhooksRejected.WithLabelValues("too_large").Inc()
+		webhookError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("webhook is larger than %d bytes", h.maxSize))
+		return
+	}
+
+	err = h.auth.verify(r, body)
+	if err != nil {
+		log.Warnf("Rejecting unauthenticated webhook from %s: %s", r.RemoteAddr, err)
+		webhooksRejected.WithLabelValues("unauthorized").Inc()
+		if h.auth.token != "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		webhookError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	dsn := h.g.defaultDSN
+	env := h.g.defaultEnv
+
+	if h.proxyURL != nil && r.URL.Path != "/" {
+		project, proxyEnv, err := parseProxyPath(r.URL.Path)
+		token, ok := proxyKey(r, h.keySource)
+		if err == nil && !ok {
+			err = errors.New("missing project key")
+		} else if err == nil && !projectKeyRE.MatchString(token) {
+			err = errors.New("invalid project key")
+		}
+		if err != nil {
+			log.Warnf("Rejecting webhook for %s: %s", r.URL.Path, err)
+			webhooksRejected.WithLabelValues("bad_request").Inc()
+			webhookError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if !h.g.allowlist.allows(project, token) {
+			log.Warnf("Rejecting webhook for project %s which is not allowed", project)
+			webhooksRejected.WithLabelValues("forbidden").Inc()
+			webhookError(w, http.StatusForbidden, "project not allowed")
+			return
+		}
+
+		dsn = fmt.Sprintf("%s://%s@%s/%s", h.proxyURL.Scheme, token, h.proxyURL.Host, project)
+		if proxyEnv != "" {
+			env = proxyEnv
+		}
+		log.Debugf("dsn: %s, url: %s, env: %s", dsn, r.URL.Path, env)
+	}
+
+	msg, err := decodeWebhook(body)
+	if err != nil {
+		log.Warnf("Invalid webhook: %s", err)
+		webhooksRejected.WithLabelValues("bad_request").Inc()
+		webhookError(w, http.StatusBadRequest, fmt.Sprintf("invalid webhook: %s", err))
+		return
+	}
+	alertsDecoded.Add(float64(len(msg.Alerts)))
+
+	reqs := h.g.requests(msg, dsn, env)
+
+	// the webhook would never fit, so it is not worth a retry
+	if len(reqs) > h.q.capacity() {
+		log.Errorf("Rejecting webhook with %d alerts which exceeds the queue capacity of %d", len(reqs), h.q.capacity())
+		webhooksRejected.WithLabelValues("too_large").Inc()
+		webhookError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("webhook has more than %d alerts", h.q.capacity()))
+		return
+	}
+
+	for i := range reqs {
+		err := h.sp.write(&reqs[i])
+		if err != nil {
+			log.Errorf("Could not spool alert: %s", err)
+			for _, req := range reqs[:i] {
+				h.sp.remove(req)
+			}
+			webhookError(w, http.StatusInternalServerError, "could not spool alerts")
+			return
+		}
+	}
+
+	if err := h.q.offer(reqs); err != nil {
+		log.Warnf("Rejecting webhook with %d alerts: %s", len(reqs), err)
+		for _, req := range reqs {
+			h.sp.remove(req)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(queueRetryAfter.Seconds())))
+		webhookError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testWebhookBody = `{"version":"4","status":"firing","receiver":"team","alerts":[` +
+		`{"status":"firing","labels":{"alertname":"HighLoad"},"fingerprint":"a"}]}`
+	testWebhookTwoAlerts = `{"version":"4","status":"firing","receiver":"team","alerts":[` +
+		`{"status":"firing","labels":{"alertname":"HighLoad"},"fingerprint":"a"},` +
+		`{"status":"firing","labels":{"alertname":"HighLoad","instance":"b"},"fingerprint":"b"}]}`
+	testProjectKey = "0123456789abcdef0123456789abcdef"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		header map[string]string
+		// setup adjusts the handler, which takes webhooks to / or to
+		// proxied projects, and queues up to 2 requests
+		setup  func(h *webhookHandler)
+		status int
+	}{
+		{
+			name:   "accepted",
+			body:   testWebhookBody,
+			status: http.StatusOK,
+		},
+		{
+			name:   "proxied",
+			path:   "/42/prod",
+			body:   testWebhookBody,
+			header: map[string]string{"Authorization": "Basic " + basicAuth(testProjectKey)},
+			status: http.StatusOK,
+		},
+		{
+			name:   "not JSON",
+			body:   "{",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "wrong version",
+			body:   `{"version":"3","alerts":[]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "malformed proxied path",
+			path:   "/42/prod/extra",
+			body:   testWebhookBody,
+			header: map[string]string{"Authorization": "Basic " + basicAuth(testProjectKey)},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "missing project key",
+			path:   "/42",
+			body:   testWebhookBody,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "missing bearer token",
+			body:   testWebhookBody,
+			setup:  func(h *webhookHandler) { h.auth.token = "secret" },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "bearer token",
+			body:   testWebhookBody,
+			header: map[string]string{"Authorization": "Bearer secret"},
+			setup:  func(h *webhookHandler) { h.auth.token = "secret" },
+			status: http.StatusOK,
+		},
+		{
+			name:   "bad signature",
+			body:   testWebhookBody,
+			header: map[string]string{signatureHeader: sign("other", testWebhookBody)},
+			setup:  func(h *webhookHandler) { h.auth.hmacSecret = []byte("secret") },
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "signed",
+			body:   testWebhookBody,
+			header: map[string]string{signatureHeader: sign("secret", testWebhookBody)},
+			setup:  func(h *webhookHandler) { h.auth.hmacSecret = []byte("secret") },
+			status: http.StatusOK,
+		},
+		{
+			name:   "project not allowed",
+			path:   "/43",
+			body:   testWebhookBody,
+			header: map[string]string{"Authorization": "Basic " + basicAuth(testProjectKey)},
+			setup:  func(h *webhookHandler) { h.g.allowlist = proxyAllowlist{"42": nil} },
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "GET",
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "payload too large",
+			body:   testWebhookBody,
+			setup:  func(h *webhookHandler) { h.maxSize = 16 },
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "more alerts than the queue holds",
+			body:   testWebhookTwoAlerts,
+			setup:  func(h *webhookHandler) { h.q = newQueue(1, 1) },
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "spool unavailable",
+			body: testWebhookBody,
+			setup: func(h *webhookHandler) {
+				h.sp = &spool{dir: filepath.Join(h.sp.dir, "missing")}
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "queue full",
+			body: testWebhookTwoAlerts,
+			setup: func(h *webhookHandler) {
+				h.q.offer([]gatewayRequest{{}})
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name:   "queue closed",
+			body:   testWebhookBody,
+			setup:  func(h *webhookHandler) { h.q.close() },
+			status: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		sp, err := newSpool(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := newTestGateway(t, &routeConfig{})
+		g.defaultDSN = "https://key@sentry.example.com/1"
+
+		h := &webhookHandler{
+			g:         g,
+			q:         newQueue(2, 1),
+			sp:        sp,
+			auth:      &webhookAuth{},
+			maxSize:   defaultMaxWebhookSize,
+			proxyURL:  &url.URL{Scheme: "https", Host: "sentry.example.com"},
+			keySource: proxyKeyBasicAuth,
+		}
+		if tt.setup != nil {
+			tt.setup(h)
+		}
+
+		method := tt.method
+		if method == "" {
+			method = http.MethodPost
+		}
+		path := tt.path
+		if path == "" {
+			path = "/"
+		}
+		r := httptest.NewRequest(method, path, strings.NewReader(tt.body))
+		for name, value := range tt.header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+			continue
+		}
+
+		if tt.status == http.StatusOK {
+			if n := h.q.len(); n != 1 {
+				t.Errorf("%s: got %d queued requests, want 1", tt.name, n)
+			}
+			if reqs, _ := h.sp.load(g.root); len(reqs) != 1 {
+				t.Errorf("%s: got %d spooled requests, want 1", tt.name, len(reqs))
+			}
+			continue
+		}
+
+		var body map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["error"] == "" {
+			t.Errorf("%s: got body %q, want a JSON error", tt.name, w.Body)
+		}
+		if tt.status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After", tt.name)
+		}
+		if tt.status == http.StatusMethodNotAllowed && w.Header().Get("Allow") != http.MethodPost {
+			t.Errorf("%s: got Allow %q", tt.name, w.Header().Get("Allow"))
+		}
+		// refused alerts do not linger in the spool
+		if reqs, _ := sp.load(g.root); len(reqs) != 0 {
+			t.Errorf("%s: got %d spooled requests, want none", tt.name, len(reqs))
+		}
+	}
+}
+
+func TestWebhookHandlerProxiedDSN(t *testing.T) {
+	g := newTestGateway(t, &routeConfig{})
+	g.defaultDSN = "https://key@sentry.example.com/1"
+	g.defaultEnv = "default"
+	h := &webhookHandler{
+		g:         g,
+		q:         newQueue(2, 1),
+		auth:      &webhookAuth{},
+		maxSize:   defaultMaxWebhookSize,
+		proxyURL:  &url.URL{Scheme: "https", Host: "sentry.example.com"},
+		keySource: proxyKeyQuery,
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/42/prod?"+proxyKeyParam+"="+testProjectKey, strings.NewReader(testWebhookBody))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+
+	req, _ := h.q.get(0)
+	if want := "https://" + testProjectKey + "@sentry.example.com/42"; req.dsn != want || req.env != "prod" {
+		t.Errorf("got %s %s, want %s prod", req.dsn, req.env, want)
+	}
+}
+
+func basicAuth(user string) string {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.SetBasicAuth(user, "")
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Basic ")
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
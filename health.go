@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// readinessMaxFailures is how many sends in a row to the default DSN
+	// may fail before the gateway reports itself as not ready.
+	readinessMaxFailures = 3
+	// readinessFailureWindow is how long after the last failure the gateway
+	// stays not ready, as once out of the Service it gets no webhooks whose
+	// sends could succeed.
+	readinessFailureWindow = 5 * time.Minute
+)
+
+// health tracks the state reported by the readiness endpoint.
+type health struct {
+	q   *queue
+	dsn string
+
+	mu          sync.Mutex
+	failures    int
+	lastFailure time.Time
+}
+
+func newHealth(q *queue, defaultDSN string) *health {
+	return &health{q: q, dsn: defaultDSN}
+}
+
+// record takes note of the outcome of a send to the given DSN.
+func (h *health) record(dsn string, err error) {
+	if dsn != h.dsn {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		h.failures = 0
+	} else {
+		h.failures++
+		h.lastFailure = time.Now()
+	}
+}
+
+func (h *health) ready() error {
+	if h.q.len() >= h.q.capacity() {
+		return errors.New("delivery queue is full")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.failures >= readinessMaxFailures && time.Since(h.lastFailure) < readinessFailureWindow {
+		return fmt.Errorf("last %d sends to the default DSN failed", h.failures)
+	}
+	return nil
+}
+
+func (h *health) handleHealthy(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "OK")
+}
+
+func (h *health) handleReady(w http.ResponseWriter, r *http.Request) {
+	err := h.ready()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "OK")
+}
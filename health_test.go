@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthReady(t *testing.T) {
+	const dsn = "https://key@sentry.example.com/1"
+	q := newQueue(1, 1)
+	h := newHealth(q, dsn)
+
+	if err := h.ready(); err != nil {
+		t.Fatalf("fresh gateway not ready: %s", err)
+	}
+
+	failed := errors.New("failed")
+	for i := 0; i < readinessMaxFailures-1; i++ {
+		h.record(dsn, failed)
+	}
+	// other DSNs do not count
+	h.record("https://key@sentry.example.com/2", failed)
+	if err := h.ready(); err != nil {
+		t.Errorf("not ready after %d failures: %s", readinessMaxFailures-1, err)
+	}
+
+	h.record(dsn, failed)
+	if err := h.ready(); err == nil {
+		t.Errorf("ready after %d failures", readinessMaxFailures)
+	}
+
+	h.lastFailure = time.Now().Add(-readinessFailureWindow)
+	if err := h.ready(); err != nil {
+		t.Errorf("not ready once the failures expired: %s", err)
+	}
+
+	h.record(dsn, failed)
+	if err := h.ready(); err == nil {
+		t.Error("ready after a new failure")
+	}
+	h.record(dsn, nil)
+	if err := h.ready(); err != nil {
+		t.Errorf("not ready after a success: %s", err)
+	}
+
+	q.offer([]gatewayRequest{{dsn: dsn}})
+	if err := h.ready(); err == nil {
+		t.Error("ready with a full queue")
+	}
+}
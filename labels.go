@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+// Sentry drops tags with longer keys and truncates longer values.
+const (
+	maxTagKeyLength   = 32
+	maxTagValueLength = 200
+)
+
+var invalidTagKeyRE = regexp.MustCompile(`[^a-zA-Z0-9_.:-]`)
+
+// labelConfig selects which alert labels become event tags.
+type labelConfig struct {
+	// Include, when given, are the only labels kept. Both lists are
+	// anchored regular expressions of label names.
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+	// Rename maps label names to the tag names they are kept as.
+	Rename map[string]string `yaml:"rename"`
+}
+
+type labelFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	rename  map[string]string
+}
+
+func newLabelFilter(cfg labelConfig) (*labelFilter, error) {
+	f := &labelFilter{rename: cfg.Rename}
+
+	for _, s := range cfg.Include {
+		re, err := regexp.Compile("^(?:" + s + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("include %q: %s", s, err)
+		}
+		f.include = append(f.include, re)
+	}
+	for _, s := range cfg.Exclude {
+		re, err := regexp.Compile("^(?:" + s + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("exclude %q: %s", s, err)
+		}
+		f.exclude = append(f.exclude, re)
+	}
+
+	return f, nil
+}
+
+// keeps tells whether the label becomes a tag, a nil filter keeping all.
+func (f *labelFilter) keeps(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *labelFilter) tagName(name string) string {
+	if f != nil && f.rename[name] != "" {
+		return f.rename[name]
+	}
+	return name
+}
+
+// sanitizeTags returns the tags made acceptable to Sentry, along with the
+// original values of those it had to alter. Tags whose names are valid keep
+// them, and altered names colliding with another tag get a numbered suffix,
+// given in name order so that it is the same for every event.
+func sanitizeTags(tags map[string]string) (map[string]string, map[string]string) {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	taken := map[string]bool{}
+	for _, name := range names {
+		if sanitizeTagKey(name) == name {
+			taken[name] = true
+		}
+	}
+
+	sanitized := map[string]string{}
+	altered := map[string]string{}
+	for _, name := range names {
+		key := name
+		if !taken[name] {
+			key = uniqueTagKey(sanitizeTagKey(name), taken)
+			taken[key] = true
+		}
+
+		value := tags[name]
+		v := strings.Join(strings.Fields(value), " ")
+		if utf8.RuneCountInString(v) > maxTagValueLength {
+			v = string([]rune(v)[:maxTagValueLength-3]) + "..."
+		}
+
+		if key != name || v != value {
+			altered[name] = value
+		}
+		if v != "" {
+			sanitized[key] = v
+		}
+	}
+	return sanitized, altered
+}
+
+func sanitizeTagKey(name string) string {
+	// invalid characters are replaced one for one, so the key is ASCII
+	key := invalidTagKeyRE.ReplaceAllString(name, "_")
+	if len(key) > maxTagKeyLength {
+		key = key[:maxTagKeyLength]
+	}
+	return key
+}
+
+// uniqueTagKey numbers the key until it is not taken, keeping it short
+// enough.
+func uniqueTagKey(key string, taken map[string]bool) string {
+	unique := key
+	for i := 2; taken[unique]; i++ {
+		suffix := fmt.Sprintf("_%d", i)
+		if len(key)+len(suffix) > maxTagKeyLength {
+			unique = key[:maxTagKeyLength-len(suffix)] + suffix
+		} else {
+			unique = key + suffix
+		}
+	}
+	return unique
+}
+
+// getEventTags returns the tags of the alert's labels along with the labels
+// which were dropped.
+func getEventTags(alert amtemplate.Alert, labels *labelFilter) (map[string]string, map[string]string) {
+	tags := make(map[string]string)
+	dropped := make(map[string]string)
+	for _, label := range alert.Labels.SortedPairs() {
+		if labels.keeps(label.Name) {
+			tags[labels.tagName(label.Name)] = label.Value
+		} else {
+			dropped[label.Name] = label.Value
+		}
+	}
+	return tags, dropped
+}
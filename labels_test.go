@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+func TestSanitizeTags(t *testing.T) {
+	long := strings.Repeat("a", 250)
+	longUTF8 := strings.Repeat("é", 250)
+
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		want     map[string]string
+		original map[string]string
+	}{
+		{
+			name: "valid",
+			tags: map[string]string{"alertname": "HighLoad", "k8s.io:app-name": "db"},
+			want: map[string]string{"alertname": "HighLoad", "k8s.io:app-name": "db"},
+		},
+		{
+			name:     "invalid characters",
+			tags:     map[string]string{"team name/é": "db"},
+			want:     map[string]string{"team_name__": "db"},
+			original: map[string]string{"team name/é": "db"},
+		},
+		{
+			name:     "long name",
+			tags:     map[string]string{"a_very_long_label_name_exceeding_32_chars": "x"},
+			want:     map[string]string{"a_very_long_label_name_exceeding": "x"},
+			original: map[string]string{"a_very_long_label_name_exceeding_32_chars": "x"},
+		},
+		{
+			name:     "whitespace",
+			tags:     map[string]string{"summary": "  load\n high\t now "},
+			want:     map[string]string{"summary": "load high now"},
+			original: map[string]string{"summary": "  load\n high\t now "},
+		},
+		{
+			name:     "blank value",
+			tags:     map[string]string{"summary": " \n "},
+			want:     map[string]string{},
+			original: map[string]string{"summary": " \n "},
+		},
+		{
+			name:     "long value",
+			tags:     map[string]string{"description": long},
+			want:     map[string]string{"description": long[:197] + "..."},
+			original: map[string]string{"description": long},
+		},
+		{
+			name:     "long UTF-8 value cut on a rune",
+			tags:     map[string]string{"description": longUTF8},
+			want:     map[string]string{"description": strings.Repeat("é", 197) + "..."},
+			original: map[string]string{"description": longUTF8},
+		},
+		{
+			name: "truncated names colliding",
+			tags: map[string]string{
+				"kubernetes_pod_label_app_kubernetes_io_name":     "db",
+				"kubernetes_pod_label_app_kubernetes_io_instance": "db-1",
+			},
+			want: map[string]string{
+				"kubernetes_pod_label_app_kuberne": "db-1",
+				"kubernetes_pod_label_app_kuber_2": "db",
+			},
+			original: map[string]string{
+				"kubernetes_pod_label_app_kubernetes_io_name":     "db",
+				"kubernetes_pod_label_app_kubernetes_io_instance": "db-1",
+			},
+		},
+		{
+			name:     "altered name colliding with a valid one",
+			tags:     map[string]string{"team name": "web", "team_name": "db"},
+			want:     map[string]string{"team_name": "db", "team_name_2": "web"},
+			original: map[string]string{"team name": "web"},
+		},
+		{
+			name:     "suffix colliding with a valid name",
+			tags:     map[string]string{"team name": "web", "team_name": "db", "team_name_2": "ops"},
+			want:     map[string]string{"team_name": "db", "team_name_2": "ops", "team_name_3": "web"},
+			original: map[string]string{"team name": "web"},
+		},
+	}
+
+	for _, tt := range tests {
+		if tt.original == nil {
+			tt.original = map[string]string{}
+		}
+
+		// collisions must be resolved the same way every time
+		for i := 0; i < 20; i++ {
+			got, original := sanitizeTags(tt.tags)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: got tags %v, want %v", tt.name, got, tt.want)
+				break
+			}
+			if !reflect.DeepEqual(original, tt.original) {
+				t.Errorf("%s: got original tags %v, want %v", tt.name, original, tt.original)
+				break
+			}
+		}
+	}
+}
+
+func TestGetEventTags(t *testing.T) {
+	f, err := newLabelFilter(labelConfig{
+		Exclude: []string{"pod|instance_.*"},
+		Rename:  map[string]string{"severity": "level_label"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := amtemplate.Alert{Labels: amtemplate.KV{
+		"alertname":   "HighLoad",
+		"severity":    "critical",
+		"pod":         "db-0",
+		"instance_ip": "10.0.0.1",
+		"podname":     "db",
+	}}
+	tags, dropped := getEventTags(alert, f)
+
+	wantTags := map[string]string{"alertname": "HighLoad", "level_label": "critical", "podname": "db"}
+	if !reflect.DeepEqual(tags, wantTags) {
+		t.Errorf("got tags %v, want %v", tags, wantTags)
+	}
+	wantDropped := map[string]string{"pod": "db-0", "instance_ip": "10.0.0.1"}
+	if !reflect.DeepEqual(dropped, wantDropped) {
+		t.Errorf("got dropped %v, want %v", dropped, wantDropped)
+	}
+}
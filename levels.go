@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	sentry "github.com/getsentry/sentry-go"
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+// levelConfig maps alert labels to Sentry event levels.
+type levelConfig struct {
+	// Labels are looked up in order, the first one present on the alert
+	// and matching one of the Values wins.
+	Labels []string             `yaml:"labels"`
+	Values []levelMappingConfig `yaml:"values"`
+	// Default is used when no label matches.
+	Default string `yaml:"default"`
+	// Status overrides the level for alerts with the given status.
+	Status map[string]string `yaml:"status"`
+}
+
+type levelMappingConfig struct {
+	Value   string `yaml:"value"`
+	ValueRE string `yaml:"value_re"`
+	Level   string `yaml:"level"`
+}
+
+// defaultLevelConfig is the mapping used without a configuration file.
+var defaultLevelConfig = levelConfig{
+	Labels: []string{"severity"},
+	Values: []levelMappingConfig{
+		{Value: "info", Level: "info"},
+		{Value: "warning", Level: "warning"},
+		{Value: "error", Level: "error"},
+		{Value: "critical", Level: "fatal"},
+	},
+	Default: "error",
+}
+
+// withDefaults fills in the labels, values and default left out of the
+// configuration from defaultLevelConfig.
+func (cfg levelConfig) withDefaults() levelConfig {
+	if cfg.Labels == nil {
+		cfg.Labels = defaultLevelConfig.Labels
+	}
+	if cfg.Values == nil {
+		cfg.Values = defaultLevelConfig.Values
+	}
+	if cfg.Default == "" {
+		cfg.Default = defaultLevelConfig.Default
+	}
+	return cfg
+}
+
+type levelRule struct {
+	re    *regexp.Regexp
+	level sentry.Level
+}
+
+type levelMapper struct {
+	labels   []string
+	rules    []levelRule
+	fallback sentry.Level
+	status   map[string]sentry.Level
+}
+
+func parseLevel(s string) (sentry.Level, error) {
+	switch level := sentry.Level(s); level {
+	case sentry.LevelDebug, sentry.LevelInfo, sentry.LevelWarning, sentry.LevelError, sentry.LevelFatal:
+		return level, nil
+	}
+	return "", fmt.Errorf("unknown Sentry level: %s", s)
+}
+
+func newLevelMapper(cfg levelConfig) (*levelMapper, error) {
+	m := &levelMapper{
+		labels:   cfg.Labels,
+		fallback: sentry.LevelError,
+		status:   map[string]sentry.Level{},
+	}
+
+	for _, v := range cfg.Values {
+		level, err := parseLevel(v.Level)
+		if err != nil {
+			return nil, err
+		}
+
+		pattern := regexp.QuoteMeta(v.Value)
+		if v.ValueRE != "" {
+			pattern = v.ValueRE
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+
+		m.rules = append(m.rules, levelRule{re, level})
+	}
+
+	if cfg.Default != "" {
+		level, err := parseLevel(cfg.Default)
+		if err != nil {
+			return nil, err
+		}
+		m.fallback = level
+	}
+
+	for status, s := range cfg.Status {
+		level, err := parseLevel(s)
+		if err != nil {
+			return nil, err
+		}
+		m.status[status] = level
+	}
+
+	return m, nil
+}
+
+func (m *levelMapper) level(alert amtemplate.Alert) sentry.Level {
+	if level, ok := m.status[alert.Status]; ok {
+		return level
+	}
+
+	for _, name := range m.labels {
+		value, ok := alert.Labels[name]
+		if !ok {
+			continue
+		}
+		for _, rule := range m.rules {
+			if rule.re.MatchString(value) {
+				return rule.level
+			}
+		}
+	}
+
+	return m.fallback
+}
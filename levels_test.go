@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	sentry "github.com/getsentry/sentry-go"
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+func TestLevelConfigWithDefaults(t *testing.T) {
+	cfg := levelConfig{Status: map[string]string{"resolved": "info"}}
+	levels, err := newLevelMapper(cfg.withDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status   string
+		severity string
+		want     sentry.Level
+	}{
+		{"firing", "critical", sentry.LevelFatal},
+		{"firing", "warning", sentry.LevelWarning},
+		{"firing", "unknown", sentry.LevelError},
+		{"resolved", "critical", sentry.LevelInfo},
+	}
+	for _, tt := range tests {
+		alert := amtemplate.Alert{Status: tt.status, Labels: amtemplate.KV{"severity": tt.severity}}
+		if got := levels.level(alert); got != tt.want {
+			t.Errorf("%s %s: got %s, want %s", tt.status, tt.severity, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "sentry_gateway"
+
+var (
+	webhooksReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "webhooks_received_total",
+		Help:      "Number of webhook requests received from Alertmanager.",
+	})
+	webhooksRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "webhooks_rejected_total",
+		Help:      "Number of webhook requests rejected, by reason.",
+	}, []string{"reason"})
+	alertsDecoded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "alerts_decoded_total",
+		Help:      "Number of alerts decoded from webhooks.",
+	})
+	eventsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_sent_total",
+		Help:      "Number of events delivered to Sentry.",
+	}, []string{"project", "environment"})
+	eventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_dropped_total",
+		Help:      "Number of events that could not be delivered to Sentry.",
+	}, []string{"project", "environment"})
+	issuesResolved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "issues_resolved_total",
+		Help:      "Number of Sentry issues resolved following resolved alerts.",
+	}, []string{"project"})
+	templateErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "template_errors_total",
+		Help:      "Number of failed template executions.",
+	}, []string{"template"})
+	annotationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "annotation_errors_total",
+		Help:      "Number of invalid override annotations ignored.",
+	}, []string{"annotation"})
+	sentryClientsCached = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "sentry_clients",
+		Help:      "Number of cached Sentry clients.",
+	})
+	sentryClientsEvicted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sentry_clients_evicted_total",
+		Help:      "Number of Sentry clients evicted from the cache, by reason.",
+	}, []string{"reason"})
+	sendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "event_send_duration_seconds",
+		Help:      "Time taken to send an event to Sentry, retries included.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"project", "environment"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		webhooksReceived,
+		webhooksRejected,
+		alertsDecoded,
+		eventsSent,
+		eventsDropped,
+		issuesResolved,
+		templateErrors,
+		annotationErrors,
+		sentryClientsCached,
+		sentryClientsEvicted,
+		sendDuration,
+	)
+}
+
+// registerQueueMetrics exposes the depth and capacity of the delivery queue.
+func registerQueueMetrics(q *queue) {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "queue_length",
+			Help:      "Number of alerts waiting to be sent to Sentry.",
+		}, func() float64 { return float64(q.len()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "queue_capacity",
+			Help:      "Maximum number of alerts waiting to be sent to Sentry.",
+		}, func() float64 { return float64(q.capacity()) }),
+	)
+}
+
+// dsnProject returns the project id of a DSN, keeping keys out of metric
+// labels.
+func dsnProject(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return ""
+	}
+	return u.Path[strings.LastIndex(u.Path, "/")+1:]
+}
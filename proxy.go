@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	// where the project key of proxied DSNs is taken from
+	proxyKeyBasicAuth = "basic-auth"
+	proxyKeyQuery     = "query"
+	proxyKeyParam     = "sentry_key"
+)
+
+var (
+	projectIDRE  = regexp.MustCompile(`^[0-9]+$`)
+	projectKeyRE = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+)
+
+// proxyKey returns the project key a webhook for a proxied DSN carries.
+func proxyKey(r *http.Request, source string) (string, bool) {
+	if source == proxyKeyQuery {
+		key := r.URL.Query().Get(proxyKeyParam)
+		return key, key != ""
+	}
+
+	key, _, ok := r.BasicAuth()
+	return key, ok
+}
+
+// parseProxyPath splits the path of a webhook for a proxied DSN, which is
+// /<project_id> or /<project_id>/<environment>.
+func parseProxyPath(path string) (string, string, error) {
+	// a single trailing slash is tolerated, /42// has an empty environment
+	params := strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/"), "/")
+	if len(params) > 2 {
+		return "", "", fmt.Errorf("expected /<project_id>[/<environment>], got %s", path)
+	}
+	if !projectIDRE.MatchString(params[0]) {
+		return "", "", fmt.Errorf("invalid project ID %q", params[0])
+	}
+
+	var env string
+	if len(params) == 2 {
+		env = params[1]
+		if env == "" {
+			return "", "", errors.New("empty environment")
+		}
+	}
+	return params[0], env, nil
+}
+
+// proxyAllowlist holds the projects webhooks may be proxied to, along with
+// the keys they may be proxied with. No keys means any key.
+type proxyAllowlist map[string][]string
+
+func newProxyAllowlist(cfg []allowedProjectConfig) (proxyAllowlist, error) {
+	allowlist := proxyAllowlist{}
+	for _, project := range cfg {
+		if !projectIDRE.MatchString(project.Project) {
+			return nil, fmt.Errorf("invalid allowed project ID %q", project.Project)
+		}
+		for _, key := range project.Keys {
+			if !projectKeyRE.MatchString(key) {
+				return nil, fmt.Errorf("invalid key for allowed project %s", project.Project)
+			}
+		}
+		allowlist[project.Project] = append(allowlist[project.Project], project.Keys...)
+	}
+	return allowlist, nil
+}
+
+// allows tells whether webhooks may be proxied to the project with the key,
+// a nil allowlist allowing everything.
+func (a proxyAllowlist) allows(project, key string) bool {
+	if a == nil {
+		return true
+	}
+
+	keys, ok := a[project]
+	if !ok {
+		return false
+	}
+	if len(keys) == 0 {
+		return true
+	}
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseProxyPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		project string
+		env     string
+		ok      bool
+	}{
+		{"/42", "42", "", true},
+		{"/42/", "42", "", true},
+		{"/42/prod", "42", "prod", true},
+		{"/42/prod/", "42", "prod", true},
+		{"/42//", "", "", false},
+		{"//42", "", "", false},
+		{"/abc", "", "", false},
+		{"/42abc", "", "", false},
+		{"/1/2/3", "", "", false},
+		{"/", "", "", false},
+	}
+
+	for _, tt := range tests {
+		project, env, err := parseProxyPath(tt.path)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v, want ok %t", tt.path, err, tt.ok)
+			continue
+		}
+		if project != tt.project || env != tt.env {
+			t.Errorf("%s: got %q %q, want %q %q", tt.path, project, env, tt.project, tt.env)
+		}
+	}
+}
+
+func TestProxyKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/42?"+proxyKeyParam+"=fromquery", nil)
+	r.SetBasicAuth("fromauth", "")
+
+	if key, ok := proxyKey(r, proxyKeyBasicAuth); !ok || key != "fromauth" {
+		t.Errorf("basic auth: got %q %t", key, ok)
+	}
+	if key, ok := proxyKey(r, proxyKeyQuery); !ok || key != "fromquery" {
+		t.Errorf("query: got %q %t", key, ok)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/42", nil)
+	if _, ok := proxyKey(r, proxyKeyBasicAuth); ok {
+		t.Error("basic auth: got a key without one")
+	}
+	if _, ok := proxyKey(r, proxyKeyQuery); ok {
+		t.Error("query: got a key without one")
+	}
+}
+
+func TestProxyAllowlist(t *testing.T) {
+	allowlist, err := newProxyAllowlist([]allowedProjectConfig{
+		{Project: "42"},
+		{Project: "43", Keys: []string{"key1"}},
+		{Project: "43", Keys: []string{"key2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		project string
+		key     string
+		allowed bool
+	}{
+		{"42", "any", true},
+		{"43", "key1", true},
+		{"43", "key2", true},
+		{"43", "key3", false},
+		{"44", "key1", false},
+	}
+	for _, tt := range tests {
+		if got := allowlist.allows(tt.project, tt.key); got != tt.allowed {
+			t.Errorf("%s with %s: got allowed %t, want %t", tt.project, tt.key, got, tt.allowed)
+		}
+	}
+
+	var none proxyAllowlist
+	if !none.allows("44", "any") {
+		t.Error("nil allowlist refused a project")
+	}
+	empty, err := newProxyAllowlist(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty.allows("44", "any") {
+		t.Error("empty allowlist allowed a project")
+	}
+
+	for _, cfg := range [][]allowedProjectConfig{
+		{{Project: "abc"}},
+		{{Project: "42", Keys: []string{"not-a-key"}}},
+	} {
+		if _, err := newProxyAllowlist(cfg); err == nil {
+			t.Errorf("%+v: accepted", cfg)
+		}
+	}
+}
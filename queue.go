@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errQueueFull   = errors.New("queue is full")
+	errQueueClosed = errors.New("queue is closed")
+)
+
+// queue is a bounded buffer of requests waiting to be sent to Sentry. It is
+// split into shards by DSN and environment, each of them consumed by a
+// single worker, so requests for the same pair keep their order.
+//
+// The shards are never closed, closing the queue only tells the workers to
+// stop once they are drained, so that late senders cannot panic.
+type queue struct {
+	// length is first to keep it 64-bit aligned for atomic access
+	length int64
+
+	// mu makes offers all or none, and guards closed
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	shards []chan gatewayRequest
+	max    int
+}
+
+func newQueue(capacity, shards int) *queue {
+	q := &queue{max: capacity, done: make(chan struct{})}
+	for i := 0; i < shards; i++ {
+		// any shard may end up holding the whole queue
+		q.shards = append(q.shards, make(chan gatewayRequest, capacity))
+	}
+	return q
+}
+
+func (q *queue) shardFor(req gatewayRequest) chan gatewayRequest {
+	h := fnv.New32a()
+	h.Write([]byte(req.dsn))
+	h.Write([]byte{0})
+	h.Write([]byte(req.env))
+	return q.shards[h.Sum32()%uint32(len(q.shards))]
+}
+
+// offer enqueues either all of the requests or, when there is not enough
+// room left or the queue is closed, none of them.
+func (q *queue) offer(reqs []gatewayRequest) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+	if q.max-q.len() < len(reqs) {
+		return errQueueFull
+	}
+
+	// the shards have room for the whole length, so none of this blocks
+	for _, req := range reqs {
+		atomic.AddInt64(&q.length, 1)
+		q.shardFor(req) <- req
+	}
+	return nil
+}
+
+// push enqueues the request regardless of the capacity, blocking while its
+// shard is full.
+func (q *queue) push(req gatewayRequest) error {
+	if q.isClosed() {
+		return errQueueClosed
+	}
+
+	atomic.AddInt64(&q.length, 1)
+	q.shardFor(req) <- req
+	return nil
+}
+
+// retry enqueues the request again after the delay, regardless of the
+// capacity. It counts towards the length of the queue meanwhile, so that
+// new webhooks are refused rather than piling up behind it. Requests whose
+// delay ends after the queue is closed are left out.
+func (q *queue) retry(req gatewayRequest, delay time.Duration) error {
+	if q.isClosed() {
+		return errQueueClosed
+	}
+
+	atomic.AddInt64(&q.length, 1)
+	time.AfterFunc(delay, func() {
+		if q.isClosed() {
+			atomic.AddInt64(&q.length, -1)
+			return
+		}
+		q.shardFor(req) <- req
+	})
+	return nil
+}
+
+// get returns the next request of a shard, or false once the queue is
+// closed and the shard drained.
+func (q *queue) get(shard int) (gatewayRequest, bool) {
+	var req gatewayRequest
+	select {
+	case req = <-q.shards[shard]:
+	case <-q.done:
+		select {
+		case req = <-q.shards[shard]:
+		default:
+			return req, false
+		}
+	}
+
+	atomic.AddInt64(&q.length, -1)
+	return req, true
+}
+
+// close has the workers stop once the shards are drained. Requests offered
+// afterwards, e.g. by webhooks still being handled after a shutdown timed
+// out, are refused.
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.done)
+}
+
+func (q *queue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.closed
+}
+
+func (q *queue) len() int {
+	return int(atomic.LoadInt64(&q.length))
+}
+
+func (q *queue) capacity() int {
+	return q.max
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueOfferAfterClose(t *testing.T) {
+	q := newQueue(2, 1)
+	if err := q.offer([]gatewayRequest{{dsn: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.offer([]gatewayRequest{{dsn: "a"}, {dsn: "b"}}); err != errQueueFull {
+		t.Errorf("got %v, want %v", err, errQueueFull)
+	}
+
+	q.close()
+	q.close()
+
+	if err := q.offer([]gatewayRequest{{dsn: "a"}}); err != errQueueClosed {
+		t.Errorf("offer: got %v, want %v", err, errQueueClosed)
+	}
+	if err := q.push(gatewayRequest{dsn: "a"}); err != errQueueClosed {
+		t.Errorf("push: got %v, want %v", err, errQueueClosed)
+	}
+
+	if _, ok := q.get(0); !ok {
+		t.Error("queued request lost on close")
+	}
+	if _, ok := q.get(0); ok {
+		t.Error("got a request offered after close")
+	}
+	if n := q.len(); n != 0 {
+		t.Errorf("got length %d, want 0", n)
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	q := newQueue(1, 1)
+
+	if err := q.retry(gatewayRequest{dsn: "a", retries: 1}, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// waiting requests take up room
+	if err := q.offer([]gatewayRequest{{dsn: "b"}}); err != errQueueFull {
+		t.Errorf("got %v, want %v", err, errQueueFull)
+	}
+
+	req, ok := q.get(0)
+	if !ok || req.dsn != "a" || req.retries != 1 {
+		t.Errorf("got %+v, %t", req, ok)
+	}
+	if n := q.len(); n != 0 {
+		t.Errorf("got length %d, want 0", n)
+	}
+
+	// once closed, waiting requests are left out
+	if err := q.retry(gatewayRequest{dsn: "a"}, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	q.close()
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := q.get(0); ok {
+		t.Error("got a request retried after close")
+	}
+	if n := q.len(); n != 0 {
+		t.Errorf("got length %d, want 0", n)
+	}
+	if err := q.retry(gatewayRequest{dsn: "a"}, 0); err != errQueueClosed {
+		t.Errorf("got %v, want %v", err, errQueueClosed)
+	}
+}
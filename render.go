@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newRenderCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "render [webhook.json]",
+		Short: "Print the Sentry events built from a webhook payload without sending them",
+		Long: "Reads an Alertmanager webhook payload from a file, or from stdin when none is given, " +
+			"and prints the Sentry event every alert would produce as JSON.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: render,
+	}
+}
+
+func render(cmd *cobra.Command, args []string) error {
+	// keep stdout for the events
+	log.SetOutput(os.Stderr)
+
+	err := setupDebug(cmd)
+	if err != nil {
+		return err
+	}
+
+	g, err := newGateway(cmd)
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if len(args) > 0 && args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	body, err := ioutil.ReadAll(input)
+	if err != nil {
+		return err
+	}
+
+	msg, err := decodeWebhook(body)
+	if err != nil {
+		return fmt.Errorf("invalid webhook: %s", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	for _, req := range g.requests(msg, g.defaultDSN, g.defaultEnv) {
+		event, err := g.builder.build(req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid template for alert %s: %s\n", req.alert.Labels["alertname"], err)
+			continue
+		}
+
+		err = encoder.Encode(event)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
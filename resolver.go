@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// fingerprintTag is the event tag carrying the Alertmanager fingerprint of
+// an alert, used to find its issue once the alert is resolved.
+const fingerprintTag = "alert_fingerprint"
+
+// resolver marks Sentry issues as resolved through the Sentry web API.
+type resolver struct {
+	// baseURL of the Sentry web API, derived from the DSN when empty
+	baseURL string
+	org     string
+	token   string
+	client  *http.Client
+}
+
+func newResolver(baseURL, org, token string) *resolver {
+	return &resolver{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		org:     org,
+		token:   token,
+		client:  &http.Client{Timeout: transportTimeout},
+	}
+}
+
+type sentryIssue struct {
+	ID string `json:"id"`
+}
+
+// resolve marks every unresolved issue of the DSN's project carrying the
+// fingerprint tag as resolved, returning how many there were.
+func (r *resolver) resolve(dsn, fingerprint string) (int, error) {
+	if fingerprint == "" {
+		return 0, &permanentError{errors.New("alert has no fingerprint")}
+	}
+
+	base, project, err := r.endpoint(dsn)
+	if err != nil {
+		return 0, &permanentError{err}
+	}
+	issuesURL := fmt.Sprintf("%s/api/0/organizations/%s/issues/", base, url.PathEscape(r.org))
+
+	query := url.Values{}
+	query.Set("project", project)
+	query.Set("query", fmt.Sprintf(`is:unresolved %s:"%s"`, fingerprintTag, fingerprint))
+
+	var issues []sentryIssue
+	err = r.do(http.MethodGet, issuesURL+"?"+query.Encode(), nil, &issues)
+	if err != nil {
+		return 0, err
+	}
+	if len(issues) == 0 {
+		return 0, nil
+	}
+
+	ids := url.Values{}
+	for _, issue := range issues {
+		ids.Add("id", issue.ID)
+	}
+	err = r.do(http.MethodPut, issuesURL+"?"+ids.Encode(), map[string]string{"status": "resolved"}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(issues), nil
+}
+
+// endpoint returns the API base url and the project id for a DSN.
+func (r *resolver) endpoint(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || u.Path[i+1:] == "" {
+		return "", "", fmt.Errorf("no project id in DSN")
+	}
+	project := u.Path[i+1:]
+
+	if r.baseURL != "" {
+		return r.baseURL, project, nil
+	}
+	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path[:i]), project, nil
+}
+
+func (r *resolver) do(method, rawURL string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, rawURL, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+r.token)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests:
+		io.Copy(ioutil.Discard, response.Body)
+		return fmt.Errorf("Sentry API responded with %s", response.Status)
+	case response.StatusCode >= 300:
+		io.Copy(ioutil.Discard, response.Body)
+		return &permanentError{fmt.Errorf("Sentry API rejected the request with %s", response.Status)}
+	}
+
+	if result == nil {
+		io.Copy(ioutil.Discard, response.Body)
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
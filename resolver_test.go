@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolverResolve(t *testing.T) {
+	var resolved []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("%s: got Authorization %q", r.Method, auth)
+		}
+		if r.URL.Path != "/api/0/organizations/acme/issues/" {
+			t.Errorf("%s: got path %s", r.Method, r.URL.Path)
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			if project := query.Get("project"); project != "42" {
+				t.Errorf("GET: got project %q", project)
+			}
+			if q := query.Get("query"); q != `is:unresolved alert_fingerprint:"abc123"` {
+				t.Errorf("GET: got query %q", q)
+			}
+			w.Write([]byte(`[{"id":"1"},{"id":"2"}]`))
+
+		case http.MethodPut:
+			resolved = r.URL.Query()["id"]
+
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("PUT: %s", err)
+			}
+			if !reflect.DeepEqual(body, map[string]string{"status": "resolved"}) {
+				t.Errorf("PUT: got body %v", body)
+			}
+			if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("PUT: got Content-Type %q", ct)
+			}
+			w.Write([]byte(`{"status":"resolved"}`))
+
+		default:
+			t.Errorf("unexpected %s", r.Method)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	dsn := strings.Replace(server.URL, "://", "://key@", 1) + "/42"
+	n, err := newResolver("", "acme", "secret").resolve(dsn, "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d resolved issues, want 2", n)
+	}
+	if want := []string{"1", "2"}; !reflect.DeepEqual(resolved, want) {
+		t.Errorf("got resolved ids %v, want %v", resolved, want)
+	}
+}
+
+func TestResolverResolveNoIssues(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected %s", r.Method)
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	n, err := newResolver(server.URL, "acme", "secret").resolve("http://key@sentry.invalid/42", "abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d resolved issues, want 0", n)
+	}
+}
+
+func TestResolverResolveErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusInternalServerError, false},
+		{http.StatusBadGateway, false},
+		{http.StatusServiceUnavailable, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusBadRequest, true},
+		{http.StatusUnauthorized, true},
+		{http.StatusForbidden, true},
+		{http.StatusNotFound, true},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+
+		_, err := newResolver(server.URL, "acme", "secret").resolve("http://key@sentry.invalid/42", "abc123")
+		server.Close()
+
+		if err == nil {
+			t.Errorf("%d: got no error", tt.status)
+			continue
+		}
+		if isPermanent(err) != tt.permanent {
+			t.Errorf("%d: got permanent %t, want %t", tt.status, isPermanent(err), tt.permanent)
+		}
+	}
+}
+
+func TestResolverResolveNoFingerprint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected %s %s", r.Method, r.URL)
+	}))
+	defer server.Close()
+
+	_, err := newResolver(server.URL, "acme", "secret").resolve("http://key@sentry.invalid/42", "")
+	if err == nil || !isPermanent(err) {
+		t.Errorf("got %v, want a permanent error", err)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	sentry "github.com/getsentry/sentry-go"
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+type matchType string
+
+const (
+	matchEqual     matchType = "="
+	matchNotEqual  matchType = "!="
+	matchRegexp    matchType = "=~"
+	matchNotRegexp matchType = "!~"
+)
+
+type matcher struct {
+	name  string
+	op    matchType
+	value string
+	re    *regexp.Regexp
+}
+
+func newMatcher(name string, op matchType, value string) (*matcher, error) {
+	m := &matcher{name: name, op: op, value: value}
+	if op == matchRegexp || op == matchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// parseMatcher parses a matcher in the Alertmanager form, e.g.
+// `severity!="info"` or `instance=~"db-.*"`.
+func parseMatcher(s string) (*matcher, error) {
+	s = strings.TrimSpace(s)
+
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid matcher: %s", s)
+	}
+	name := strings.TrimSpace(s[:i])
+	rest := s[i:]
+
+	var op matchType
+	for _, o := range []matchType{matchRegexp, matchNotRegexp, matchNotEqual, matchEqual} {
+		if strings.HasPrefix(rest, string(o)) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return nil, fmt.Errorf("invalid matcher: %s", s)
+	}
+
+	value := strings.TrimSpace(rest[len(op):])
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher value: %s", s)
+		}
+		value = unquoted
+	}
+
+	return newMatcher(name, op, value)
+}
+
+func (m *matcher) matches(labels amtemplate.KV) bool {
+	v := labels[m.name]
+	switch m.op {
+	case matchEqual:
+		return v == m.value
+	case matchNotEqual:
+		return v != m.value
+	case matchRegexp:
+		return m.re.MatchString(v)
+	case matchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+// templateSources are the sources of the templates of a route, kept so that
+// inherited templates can be compiled again when html_templates changes.
+type templateSources struct {
+	template    string
+	fingerprint []string
+	tags        map[string]string
+	extra       map[string]string
+	contexts    map[string]map[string]string
+	// fields are by the names of routeConfig.eventFields
+	fields    map[string]string
+	exception map[string]string
+	group     string
+}
+
+// mergeSources returns the named sources on top of inherited ones, a name
+// given in both taking the new source.
+func mergeSources(inherited, sources map[string]string) map[string]string {
+	merged := map[string]string{}
+	for name, s := range inherited {
+		merged[name] = s
+	}
+	for name, s := range sources {
+		merged[name] = s
+	}
+	return merged
+}
+
+// route is a resolved node of the routing tree, with every setting already
+// inherited from its parents and every template compiled.
+type route struct {
+	id       string
+	matchers []*matcher
+	cont     bool
+
+	// dsn and env are only set when given in the config file below the
+	// root route, so that DSN proxying and the defaults act as fallbacks.
+	dsn string
+	env string
+
+	// html has templates HTML-escape their output, the inherited ones
+	// included
+	html                 bool
+	sources              templateSources
+	template             eventTemplate
+	fingerprintTemplates []eventTemplate
+	tagTemplates         map[string]eventTemplate
+	extraTemplates       map[string]eventTemplate
+	contextTemplates     map[string]map[string]eventTemplate
+	fieldTemplates       map[string]eventTemplate
+
+	// exceptions has events carry a synthetic exception, its templates
+	// being by type, value and module
+	exceptions         bool
+	exceptionTemplates map[string]eventTemplate
+
+	// group has matching alerts of a notification sent as a single event
+	group         bool
+	groupTemplate eventTemplate
+
+	routes []*route
+}
+
+func newRoute(cfg *routeConfig, parent *route, id string) (*route, error) {
+	r := &route{
+		id:         id,
+		cont:       cfg.Continue,
+		html:       parent.html,
+		dsn:        parent.dsn,
+		env:        parent.env,
+		sources:    parent.sources,
+		exceptions: parent.exceptions,
+		group:      parent.group,
+	}
+
+	// like Alertmanager's, the root route takes every alert
+	if id == "root" && (len(cfg.Match) > 0 || len(cfg.MatchRE) > 0 || len(cfg.Matchers) > 0) {
+		return nil, fmt.Errorf("route %s: the root route must not have matchers", id)
+	}
+
+	// sort for a stable matcher order, maps have none
+	var names []string
+	for name := range cfg.Match {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m, err := newMatcher(name, matchEqual, cfg.Match[name])
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %s", id, err)
+		}
+		r.matchers = append(r.matchers, m)
+	}
+
+	names = nil
+	for name := range cfg.MatchRE {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m, err := newMatcher(name, matchRegexp, cfg.MatchRE[name])
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %s", id, err)
+		}
+		r.matchers = append(r.matchers, m)
+	}
+
+	for _, s := range cfg.Matchers {
+		m, err := parseMatcher(s)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %s", id, err)
+		}
+		r.matchers = append(r.matchers, m)
+	}
+
+	if cfg.DSN != "" {
+		if _, err := sentry.NewDsn(cfg.DSN); err != nil {
+			return nil, fmt.Errorf("route %s: %s", id, err)
+		}
+	}
+
+	// those of the root route are defaults instead, see newGateway
+	if id != "root" {
+		if cfg.DSN != "" {
+			r.dsn = cfg.DSN
+		}
+		if cfg.Environment != "" {
+			r.env = cfg.Environment
+		}
+	}
+
+	if cfg.HTMLTemplates != nil {
+		r.html = *cfg.HTMLTemplates
+	}
+
+	tmpl := cfg.Template
+	if cfg.TemplateFile != "" {
+		file, err := ioutil.ReadFile(cfg.TemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %s", id, err)
+		}
+		tmpl = string(file)
+	}
+	if tmpl != "" {
+		r.sources.template = tmpl
+	}
+
+	if cfg.FingerprintTemplates != nil {
+		r.sources.fingerprint = cfg.FingerprintTemplates
+	}
+
+	if cfg.Tags != nil {
+		r.sources.tags = mergeSources(parent.sources.tags, cfg.Tags)
+	}
+
+	if cfg.Extra != nil {
+		r.sources.extra = mergeSources(parent.sources.extra, cfg.Extra)
+	}
+
+	if cfg.Contexts != nil {
+		r.sources.contexts = map[string]map[string]string{}
+		for name, fields := range parent.sources.contexts {
+			r.sources.contexts[name] = fields
+		}
+		for name, fields := range cfg.Contexts {
+			r.sources.contexts[name] = mergeSources(parent.sources.contexts[name], fields)
+		}
+	}
+
+	fields, err := cfg.eventFields()
+	if err != nil {
+		return nil, fmt.Errorf("route %s: %s", id, err)
+	}
+	if len(fields) > 0 {
+		r.sources.fields = mergeSources(parent.sources.fields, fields)
+	}
+
+	if cfg.Exceptions != nil {
+		r.exceptions = *cfg.Exceptions
+	}
+
+	exceptionTemplates := map[string]string{}
+	for name, tmpl := range map[string]string{
+		"type":   cfg.ExceptionType,
+		"value":  cfg.ExceptionValue,
+		"module": cfg.ExceptionModule,
+	} {
+		if tmpl != "" {
+			exceptionTemplates[name] = tmpl
+		}
+	}
+	if len(exceptionTemplates) > 0 {
+		r.sources.exception = mergeSources(parent.sources.exception, exceptionTemplates)
+	}
+
+	if cfg.GroupAlerts != nil {
+		r.group = *cfg.GroupAlerts
+	}
+
+	groupTmpl := cfg.GroupTemplate
+	if cfg.GroupTemplateFile != "" {
+		file, err := ioutil.ReadFile(cfg.GroupTemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %s", id, err)
+		}
+		groupTmpl = string(file)
+	}
+	if groupTmpl != "" {
+		r.sources.group = groupTmpl
+	}
+
+	if err := r.compileTemplates(); err != nil {
+		return nil, fmt.Errorf("route %s: %s", id, err)
+	}
+
+	for i, childCfg := range cfg.Routes {
+		child, err := newRoute(childCfg, r, fmt.Sprintf("%s/%d", id, i))
+		if err != nil {
+			return nil, err
+		}
+		r.routes = append(r.routes, child)
+	}
+
+	return r, nil
+}
+
+// compileTemplates compiles the template sources of the route, HTML-escaping
+// or not as the route says, whichever route they come from.
+func (r *route) compileTemplates() error {
+	var err error
+
+	if r.template, err = createTemplate(r.sources.template, r.html); err != nil {
+		return err
+	}
+	if r.fingerprintTemplates, err = createTemplates(r.sources.fingerprint, r.html); err != nil {
+		return err
+	}
+	if r.tagTemplates, err = createTemplateMap(r.sources.tags, r.html); err != nil {
+		return fmt.Errorf("tag %s", err)
+	}
+	if r.extraTemplates, err = createTemplateMap(r.sources.extra, r.html); err != nil {
+		return fmt.Errorf("extra %s", err)
+	}
+
+	r.contextTemplates = map[string]map[string]eventTemplate{}
+	for name, fields := range r.sources.contexts {
+		if r.contextTemplates[name], err = createTemplateMap(fields, r.html); err != nil {
+			return fmt.Errorf("context %s field %s", name, err)
+		}
+	}
+
+	if r.fieldTemplates, err = createTemplateMap(r.sources.fields, r.html); err != nil {
+		return err
+	}
+	if r.exceptionTemplates, err = createTemplateMap(r.sources.exception, r.html); err != nil {
+		return fmt.Errorf("exception %s", err)
+	}
+	if r.groupTemplate, err = createTemplate(r.sources.group, r.html); err != nil {
+		return err
+	}
+	return nil
+}
+
+// match returns the deepest routes matching the labels, following the same
+// semantics as the Alertmanager routing tree.
+func (r *route) match(labels amtemplate.KV) []*route {
+	for _, m := range r.matchers {
+		if !m.matches(labels) {
+			return nil
+		}
+	}
+
+	var all []*route
+	for _, child := range r.routes {
+		matches := child.match(labels)
+		all = append(all, matches...)
+
+		if matches != nil && !child.cont {
+			break
+		}
+	}
+
+	if len(all) == 0 {
+		all = append(all, r)
+	}
+
+	return all
+}
+
+// find returns the route with the given id within the tree, if any.
+func (r *route) find(id string) *route {
+	if r.id == id {
+		return r
+	}
+	for _, child := range r.routes {
+		if found := child.find(id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
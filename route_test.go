@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+func TestRouteHTMLTemplatesInherited(t *testing.T) {
+	root := &route{
+		id: "root",
+		sources: templateSources{
+			template: "{{ .Annotations.summary }}",
+			tags:     map[string]string{"summary": "{{ .Annotations.summary }}"},
+			group:    defaultGroupTemplate,
+		},
+	}
+	if err := root.compileTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	html := true
+	child, err := newRoute(&routeConfig{HTMLTemplates: &html}, root, "root/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := templateData{Alert: amtemplate.Alert{Annotations: amtemplate.KV{"summary": "a < b"}}}
+	for _, tt := range []struct {
+		name string
+		tmpl eventTemplate
+		want string
+	}{
+		{"root message", root.template, "a < b"},
+		{"root tag", root.tagTemplates["summary"], "a < b"},
+		{"child message", child.template, "a &lt; b"},
+		{"child tag", child.tagTemplates["summary"], "a &lt; b"},
+	} {
+		var buf bytes.Buffer
+		if err := tt.tmpl.Execute(&buf, data); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRootRouteMatchers(t *testing.T) {
+	root := &route{id: "root"}
+	for _, cfg := range []*routeConfig{
+		{Match: map[string]string{"team": "db"}},
+		{MatchRE: map[string]string{"team": "db|cache"}},
+		{Matchers: []string{`team="db"`}},
+	} {
+		if _, err := newRoute(cfg, root, "root"); err == nil {
+			t.Errorf("%+v: root route accepted matchers", cfg)
+		}
+	}
+
+	if _, err := newRoute(&routeConfig{Routes: []*routeConfig{{Match: map[string]string{"team": "db"}}}}, root, "root"); err != nil {
+		t.Errorf("child route matchers: %s", err)
+	}
+}
+
+func TestRouteInvalidDSN(t *testing.T) {
+	root := &route{id: "root"}
+	if _, err := newRoute(&routeConfig{DSN: "htp:/nope"}, root, "root"); err == nil {
+		t.Error("invalid DSN accepted")
+	}
+	if _, err := newRoute(&routeConfig{DSN: "https://key@sentry.example.com/42"}, root, "root"); err != nil {
+		t.Errorf("valid DSN: %s", err)
+	}
+}
+
+func TestParseMatcher(t *testing.T) {
+	tests := []struct {
+		in    string
+		name  string
+		op    matchType
+		value string
+		ok    bool
+	}{
+		{`team=db`, "team", matchEqual, "db", true},
+		{`team="db"`, "team", matchEqual, "db", true},
+		{` team = "db" `, "team", matchEqual, "db", true},
+		{`team!=db`, "team", matchNotEqual, "db", true},
+		{`team!="db"`, "team", matchNotEqual, "db", true},
+		{`instance=~db-.*`, "instance", matchRegexp, "db-.*", true},
+		{`instance=~"db-.*"`, "instance", matchRegexp, "db-.*", true},
+		{`instance!~"db-.*"`, "instance", matchNotRegexp, "db-.*", true},
+		{`summary="a \"quoted\" value"`, "summary", matchEqual, `a "quoted" value`, true},
+		{`team=""`, "team", matchEqual, "", true},
+		{`team=`, "team", matchEqual, "", true},
+		{`team`, "", "", "", false},
+		{`=db`, "", "", "", false},
+		{`team!db`, "", "", "", false},
+		{`team="db`, "", "", "", false},
+		{`team=~"("`, "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		m, err := parseMatcher(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v, want ok %t", tt.in, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if m.name != tt.name || m.op != tt.op || m.value != tt.value {
+			t.Errorf("%s: got %q %s %q, want %q %s %q", tt.in, m.name, m.op, m.value, tt.name, tt.op, tt.value)
+		}
+	}
+}
+
+func TestMatcherMatches(t *testing.T) {
+	labels := amtemplate.KV{"team": "db", "instance": "db-1"}
+
+	tests := []struct {
+		name  string
+		op    matchType
+		value string
+		want  bool
+	}{
+		{"team", matchEqual, "db", true},
+		{"team", matchEqual, "web", false},
+		{"team", matchNotEqual, "web", true},
+		{"team", matchNotEqual, "db", false},
+		{"missing", matchEqual, "", true},
+		{"missing", matchNotEqual, "", false},
+		{"instance", matchRegexp, "db-.*", true},
+		{"instance", matchNotRegexp, "db-.*", false},
+		// regular expressions are anchored
+		{"instance", matchRegexp, "db", false},
+		{"instance", matchRegexp, "b-1", false},
+		{"instance", matchNotRegexp, "db", true},
+		{"team", matchRegexp, "db|web", true},
+	}
+
+	for _, tt := range tests {
+		m, err := newMatcher(tt.name, tt.op, tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.matches(labels); got != tt.want {
+			t.Errorf("%s%s%q: got %t, want %t", tt.name, tt.op, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRouteMatch(t *testing.T) {
+	g := newTestGateway(t, &routeConfig{
+		Routes: []*routeConfig{
+			{
+				Match:    map[string]string{"team": "db"},
+				Continue: true,
+				Routes: []*routeConfig{
+					{Match: map[string]string{"severity": "critical"}},
+					// shadowed by its sibling, which does not continue
+					{MatchRE: map[string]string{"severity": "crit.*"}},
+				},
+			},
+			{
+				Matchers: []string{`team=~"db|web"`},
+				Routes: []*routeConfig{
+					{Matchers: []string{`instance!="db-1"`}, Continue: true},
+					{Matchers: []string{`instance!~"db-.*"`}},
+				},
+			},
+			// only reached through a route that continues
+			{Match: map[string]string{"team": "db"}},
+		},
+	})
+
+	tests := []struct {
+		name   string
+		labels amtemplate.KV
+		want   []string
+	}{
+		{
+			name:   "deepest match and continue",
+			labels: amtemplate.KV{"team": "db", "severity": "critical", "instance": "db-1"},
+			want:   []string{"root/0/0", "root/1"},
+		},
+		{
+			name:   "parent without a matching child",
+			labels: amtemplate.KV{"team": "db", "severity": "warning", "instance": "db-2"},
+			want:   []string{"root/0", "root/1/0"},
+		},
+		{
+			name:   "continuing sibling",
+			labels: amtemplate.KV{"team": "web", "instance": "web-1"},
+			want:   []string{"root/1/0", "root/1/1"},
+		},
+		{
+			name:   "no match",
+			labels: amtemplate.KV{"team": "other"},
+			want:   []string{"root"},
+		},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, r := range g.root.match(tt.labels) {
+			got = append(got, r.id)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
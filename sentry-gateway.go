@@ -3,22 +3,23 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"html/template"
-	"io/ioutil"
+	htmltemplate "html/template"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
+	"text/template"
 	"time"
 
 	sentry "github.com/getsentry/sentry-go"
 	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -31,6 +32,12 @@ var (
 const (
 	defaultTemplate   = "{{ .Labels.alertname }} - {{ .Labels.instance }}\n{{ .Annotations.description }}"
 	defaultListenAddr = "0.0.0.0:9096"
+
+	defaultQueueCapacity = 1000
+	queueRetryAfter      = 30 * time.Second
+	defaultMaxRetries    = 5
+	defaultWorkers       = 4
+	defaultShutdownWait  = 30 * time.Second
 )
 
 func main() {
@@ -40,16 +47,41 @@ func main() {
 		RunE:  run,
 	}
 
-	cmd.Flags().StringP("dsn", "d", "", "Sentry DSN")
+	cmd.PersistentFlags().StringP("config", "c", "", "Path of the YAML configuration file")
+	cmd.PersistentFlags().StringP("dsn", "d", "", "Sentry DSN")
+	cmd.PersistentFlags().StringP("environment", "e", "", "Sentry Environment")
+	cmd.PersistentFlags().StringP("environment-label", "l", "", "Alert Label that contains sentry environment")
+	cmd.PersistentFlags().StringP("template", "t", "", "Path of the template file of event message")
+	cmd.PersistentFlags().StringArrayP("fingerprint-templates", "f", []string{}, "List of templates to use as Sentry event fingerprint")
+	cmd.PersistentFlags().Bool("html-templates", false, "Whether to HTML-escape the output of templates")
+	cmd.PersistentFlags().Bool("exceptions", false, "Whether to send alerts as synthetic exceptions")
+	cmd.PersistentFlags().BoolP("dumb-timestamps", "s", false, "Whether to use time.Now instead of alert StartsAt/EndsAt")
+	cmd.PersistentFlags().Bool("debug", false, "Enable debug output")
+
 	cmd.Flags().StringP("sentry-url", "u", "", "Sentry URL")
-	cmd.Flags().StringP("environment", "e", "", "Sentry Environment")
-	cmd.Flags().StringP("environment-label", "l", "", "Alert Label that contains sentry environment")
-	cmd.Flags().StringP("template", "t", "", "Path of the template file of event message")
-	cmd.Flags().StringArrayP("fingerprint-templates", "f", []string{}, "List of templates to use as Sentry event fingerprint")
-	cmd.Flags().BoolP("dumb-timestamps", "s", false, "Whether to use time.Now instead of alert StartsAt/EndsAt")
 	cmd.Flags().StringP("addr", "a", "", "Address to listen on for WebHook")
+	cmd.Flags().IntP("queue-capacity", "q", defaultQueueCapacity, "Maximum number of alerts waiting to be sent to Sentry")
+	cmd.Flags().String("spool-dir", "", "Directory to persist alerts in until they are delivered to Sentry")
+	cmd.Flags().Int("max-retries", defaultMaxRetries, "How many times to retry sending an event to Sentry")
+	cmd.Flags().IntP("workers", "w", defaultWorkers, "Number of workers sending events to Sentry")
+	cmd.Flags().String("tls-cert", "", "Path of the TLS certificate to serve webhooks with")
+	cmd.Flags().String("tls-key", "", "Path of the TLS private key to serve webhooks with")
+	cmd.Flags().String("tls-client-ca", "", "Path of the CA certificates to verify client certificates against")
+	cmd.Flags().Int64("max-webhook-size", defaultMaxWebhookSize, "Maximum size in bytes of webhook payloads")
+	cmd.Flags().String("auth-token", "", "Bearer token webhooks must be authenticated with")
+	cmd.Flags().String("hmac-secret", "", "Secret webhook bodies must be signed with using HMAC-SHA256")
+	cmd.Flags().String("proxy-key-from", proxyKeyBasicAuth, "Where to take the key of proxied DSNs from, one of basic-auth,query")
+	cmd.Flags().Int("client-cache-size", defaultClientCacheSize, "Maximum number of cached Sentry clients, 0 for no limit")
+	cmd.Flags().Duration("client-idle-timeout", defaultClientIdleTimeout, "How long an unused Sentry client is cached for, 0 for ever")
+	cmd.Flags().Bool("resolve-issues", false, "Whether to resolve Sentry issues when their alerts are resolved")
+	cmd.Flags().String("sentry-auth-token", "", "Sentry API auth token, used to resolve issues")
+	cmd.Flags().String("sentry-org", "", "Sentry organization slug, used to resolve issues")
+	cmd.Flags().Bool("dry-run", false, "Write events as JSON lines instead of sending them to Sentry")
+	cmd.Flags().String("dry-run-output", "-", "File to write events to in dry-run mode, - for stdout")
+	cmd.Flags().Duration("shutdown-timeout", defaultShutdownWait, "How long to wait for queued alerts to be sent on shutdown")
 	cmd.Flags().Bool("version", false, "Display version information and exit")
-	cmd.Flags().Bool("debug", false, "Enable debug output")
+
+	cmd.AddCommand(newRenderCommand())
 
 	cmd.SilenceUsage = true
 	cmd.SilenceErrors = true
@@ -65,6 +97,16 @@ type gatewayRequest struct {
 	dsn   string
 	env   string
 	alert amtemplate.Alert
+	// alerts are those of a request sent as a single event, alert standing
+	// in for all of them
+	alerts []amtemplate.Alert
+	data   *groupData
+	route  *route
+
+	// spoolFile is the name of the request's file in the spool, if any
+	spoolFile string
+	// retries is how many times the request was queued again after failing
+	retries int
 }
 
 func run(cmd *cobra.Command, args []string) error {
@@ -73,215 +115,489 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	if !cmd.Flags().Changed("dry-run") {
+		if envDR, err := strconv.ParseBool(os.Getenv("SENTRY_GATEWAY_DRY_RUN")); err == nil {
+			dryRun = envDR
+		}
+	}
+
+	output, err := cmd.Flags().GetString("dry-run-output")
+	if err != nil {
+		return err
+	}
+	if envOutput := os.Getenv("SENTRY_GATEWAY_DRY_RUN_OUTPUT"); !cmd.Flags().Changed("dry-run-output") && envOutput != "" {
+		output = envOutput
+	}
+
+	var banner io.Writer = os.Stdout
+	if dryRun && output == "-" {
+		// keep stdout for the events
+		banner = os.Stderr
+		log.SetOutput(os.Stderr)
+	}
+
 	// always print version
-	version()
+	version(banner)
 	if v {
 		os.Exit(0)
 	}
 
-	debug, err := cmd.Flags().GetBool("debug")
+	err = setupDebug(cmd)
 	if err != nil {
 		return err
 	}
-	if debug {
-		log.Info("Enabling debug output")
-		log.SetLevel(log.DebugLevel)
-	}
 
 	log.Info("Starting up...")
 
-	defaultDSN, err := cmd.Flags().GetString("dsn")
+	g, err := newGateway(cmd)
 	if err != nil {
 		return err
 	}
-	if defaultDSN == "" {
-		defaultDSN = os.Getenv("SENTRY_DSN")
+
+	sentryURL, err := cmd.Flags().GetString("sentry-url")
+	if err != nil {
+		return err
+	}
+	if sentryURL == "" {
+		sentryURL = os.Getenv("SENTRY_URL")
 	}
 
-	defaultEnv, err := cmd.Flags().GetString("environment")
+	addr, err := cmd.Flags().GetString("addr")
 	if err != nil {
 		return err
 	}
-	if defaultEnv == "" {
-		defaultEnv = os.Getenv("SENTRY_ENVIRONMENT")
+
+	if addr == "" {
+		if envAddr := os.Getenv("SENTRY_GATEWAY_ADDR"); envAddr != "" {
+			addr = envAddr
+		} else {
+			addr = defaultListenAddr
+		}
 	}
 
-	envLabel, err := cmd.Flags().GetString("environment-label")
+	tlsCert, err := cmd.Flags().GetString("tls-cert")
 	if err != nil {
 		return err
 	}
-	if envLabel == "" {
-		envLabel = os.Getenv("SENTRY_ENVIRONMENT_LABEL")
+	if tlsCert == "" {
+		tlsCert = os.Getenv("SENTRY_GATEWAY_TLS_CERT")
 	}
-	if envLabel != "" {
-		log.Infof("Using alert label '%s' to overwrite sentry environment", envLabel)
+
+	tlsKey, err := cmd.Flags().GetString("tls-key")
+	if err != nil {
+		return err
+	}
+	if tlsKey == "" {
+		tlsKey = os.Getenv("SENTRY_GATEWAY_TLS_KEY")
 	}
 
-	sentryURL, err := cmd.Flags().GetString("sentry-url")
+	tlsClientCA, err := cmd.Flags().GetString("tls-client-ca")
 	if err != nil {
 		return err
 	}
-	if sentryURL == "" {
-		sentryURL = os.Getenv("SENTRY_URL")
+	if tlsClientCA == "" {
+		tlsClientCA = os.Getenv("SENTRY_GATEWAY_TLS_CLIENT_CA")
+	}
+
+	if (tlsCert == "") != (tlsKey == "") {
+		return errors.New("`tls-cert,tls-key` must be given together")
+	}
+	if tlsClientCA != "" && tlsCert == "" {
+		return errors.New("`tls-client-ca` requires `tls-cert,tls-key`")
 	}
 
-	if defaultDSN == "" && sentryURL == "" {
-		return errors.New("one of `dsn,sentry-url` is required")
+	var tlsConfig *tls.Config
+	if tlsCert != "" {
+		tlsConfig, err = newTLSConfig(tlsCert, tlsKey, tlsClientCA)
+		if err != nil {
+			return err
+		}
+		if tlsClientCA != "" {
+			log.Infof("Requiring client certificates signed by %s", tlsClientCA)
+		}
 	}
 
-	tmplPath, err := cmd.Flags().GetString("template")
+	maxWebhookSize, err := cmd.Flags().GetInt64("max-webhook-size")
 	if err != nil {
 		return err
 	}
+	if !cmd.Flags().Changed("max-webhook-size") {
+		if envMS, err := strconv.ParseInt(os.Getenv("SENTRY_GATEWAY_MAX_WEBHOOK_SIZE"), 10, 64); err == nil {
+			maxWebhookSize = envMS
+		}
+	}
 
-	var tmpl string
-	if tmplPath != "" {
-		file, err := ioutil.ReadFile(tmplPath)
+	authToken, err := cmd.Flags().GetString("auth-token")
+	if err != nil {
+		return err
+	}
+	if authToken == "" {
+		authToken = os.Getenv("SENTRY_GATEWAY_AUTH_TOKEN")
+	}
+
+	hmacSecret, err := cmd.Flags().GetString("hmac-secret")
+	if err != nil {
+		return err
+	}
+	if hmacSecret == "" {
+		hmacSecret = os.Getenv("SENTRY_GATEWAY_HMAC_SECRET")
+	}
+
+	keySource, err := cmd.Flags().GetString("proxy-key-from")
+	if err != nil {
+		return err
+	}
+	if envKS := os.Getenv("SENTRY_GATEWAY_PROXY_KEY_FROM"); !cmd.Flags().Changed("proxy-key-from") && envKS != "" {
+		keySource = envKS
+	}
+	if keySource != proxyKeyBasicAuth && keySource != proxyKeyQuery {
+		return fmt.Errorf("invalid proxy key source %q, must be one of basic-auth,query", keySource)
+	}
+	if authToken != "" && sentryURL != "" && keySource == proxyKeyBasicAuth {
+		return errors.New("`auth-token` and DSN proxying both use the Authorization header, use `--proxy-key-from=query`")
+	}
+
+	// webhooks to other paths than / are proxied to the project they name
+	var proxyURL *url.URL
+	if sentryURL != "" {
+		proxyURL, err = url.Parse(sentryURL)
 		if err != nil {
-			return err
+			return fmt.Errorf("invalid Sentry URL: %s", err)
 		}
+	}
 
-		tmpl = string(file)
-	} else if envTmpl := os.Getenv("SENTRY_GATEWAY_TEMPLATE"); envTmpl != "" {
-		tmpl = envTmpl
-	} else {
-		tmpl = defaultTemplate
+	auth := &webhookAuth{token: authToken, hmacSecret: []byte(hmacSecret)}
+	if authToken != "" {
+		log.Info("Requiring a bearer token on webhooks")
+	}
+	if hmacSecret != "" {
+		log.Info("Requiring signed webhook bodies")
 	}
 
-	addr, err := cmd.Flags().GetString("addr")
+	var newTransport func() resultTransport
+	if dryRun {
+		var w io.Writer = os.Stdout
+		if output != "-" {
+			file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+
+		log.Infof("Dry-run mode, writing events to %s instead of Sentry", output)
+		out := &sink{w: w}
+		newTransport = func() resultTransport {
+			return &sinkTransport{sink: out}
+		}
+	}
+
+	if !dryRun && g.defaultDSN == "" && sentryURL == "" {
+		return errors.New("one of `dsn,sentry-url,config` is required")
+	}
+
+	queueCapacity, err := cmd.Flags().GetInt("queue-capacity")
 	if err != nil {
 		return err
 	}
+	if !cmd.Flags().Changed("queue-capacity") {
+		if envQC, err := strconv.Atoi(os.Getenv("SENTRY_GATEWAY_QUEUE_CAPACITY")); err == nil {
+			queueCapacity = envQC
+		}
+	}
+	if queueCapacity <= 0 {
+		return errors.New("`queue-capacity` must be positive")
+	}
 
-	if addr == "" {
-		if envAddr := os.Getenv("SENTRY_GATEWAY_ADDR"); envAddr != "" {
-			addr = envAddr
-		} else {
-			addr = defaultListenAddr
+	workers, err := cmd.Flags().GetInt("workers")
+	if err != nil {
+		return err
+	}
+	if !cmd.Flags().Changed("workers") {
+		if envW, err := strconv.Atoi(os.Getenv("SENTRY_GATEWAY_WORKERS")); err == nil {
+			workers = envW
 		}
 	}
+	if workers <= 0 {
+		return errors.New("`workers` must be positive")
+	}
 
-	t, err := createTemplate(tmpl)
+	shutdownTimeout, err := cmd.Flags().GetDuration("shutdown-timeout")
 	if err != nil {
 		return err
 	}
+	if !cmd.Flags().Changed("shutdown-timeout") {
+		if envST, err := time.ParseDuration(os.Getenv("SENTRY_GATEWAY_SHUTDOWN_TIMEOUT")); err == nil {
+			shutdownTimeout = envST
+		}
+	}
 
-	fingerprintTemplates, err := cmd.Flags().GetStringArray("fingerprint-templates")
+	resolveIssues, err := cmd.Flags().GetBool("resolve-issues")
 	if err != nil {
 		return err
 	}
-	if len(fingerprintTemplates) == 0 {
-		fingerprintTemplates = strings.Split(os.Getenv("SENTRY_GATEWAY_FINGERPRINT_TEMPLATES"), ",")
+	if !cmd.Flags().Changed("resolve-issues") {
+		if envRI, err := strconv.ParseBool(os.Getenv("SENTRY_GATEWAY_RESOLVE_ISSUES")); err == nil {
+			resolveIssues = envRI
+		}
+	}
+
+	var res *resolver
+	if resolveIssues && dryRun {
+		log.Warn("Not resolving Sentry issues in dry-run mode")
+		resolveIssues = false
 	}
+	if resolveIssues {
+		authToken, err := cmd.Flags().GetString("sentry-auth-token")
+		if err != nil {
+			return err
+		}
+		if authToken == "" {
+			authToken = os.Getenv("SENTRY_AUTH_TOKEN")
+		}
 
-	var fpTemplates []*template.Template
-	for _, templateString := range fingerprintTemplates {
-		fpTemplate, err := createTemplate(templateString)
+		org, err := cmd.Flags().GetString("sentry-org")
 		if err != nil {
 			return err
 		}
-		fpTemplates = append(fpTemplates, fpTemplate)
+		if org == "" {
+			org = os.Getenv("SENTRY_ORG")
+		}
+
+		if authToken == "" || org == "" {
+			return errors.New("`sentry-auth-token,sentry-org` are required to resolve issues")
+		}
+
+		log.Infof("Resolving Sentry issues of resolved alerts in organization %s", org)
+		res = newResolver(sentryURL, org, authToken)
+		g.builder.tagFingerprint = true
 	}
 
-	dumbTimestamps, err := cmd.Flags().GetBool("dumb-timestamps")
+	q := newQueue(queueCapacity, workers)
+	registerQueueMetrics(q)
+
+	spoolDir, err := cmd.Flags().GetString("spool-dir")
 	if err != nil {
 		return err
 	}
-	if !cmd.Flags().Changed("dumb-timestamps") {
-		if envDT, err := strconv.ParseBool(os.Getenv("SENTRY_GATEWAY_DUMB_TIMESTAMPS")); err == nil {
-			dumbTimestamps = envDT
+	if spoolDir == "" {
+		spoolDir = os.Getenv("SENTRY_GATEWAY_SPOOL_DIR")
+	}
+
+	maxRetries, err := cmd.Flags().GetInt("max-retries")
+	if err != nil {
+		return err
+	}
+	if !cmd.Flags().Changed("max-retries") {
+		if envMR, err := strconv.Atoi(os.Getenv("SENTRY_GATEWAY_MAX_RETRIES")); err == nil {
+			maxRetries = envMR
+		}
+	}
+	if newTransport == nil {
+		newTransport = func() resultTransport {
+			return newRetryTransport(maxRetries)
 		}
 	}
 
-	hookChan := make(chan gatewayRequest)
+	clientCacheSize, err := cmd.Flags().GetInt("client-cache-size")
+	if err != nil {
+		return err
+	}
+	if !cmd.Flags().Changed("client-cache-size") {
+		if envCS, err := strconv.Atoi(os.Getenv("SENTRY_GATEWAY_CLIENT_CACHE_SIZE")); err == nil {
+			clientCacheSize = envCS
+		}
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		dsn := defaultDSN
-		env := defaultEnv
-
-		if sentry, err := url.Parse(sentryURL); sentryURL != "" && err == nil {
-			if token, _, ok := r.BasicAuth(); ok && r.URL.Path != "/" {
-				params := strings.Split(r.URL.Path, "/")
-				if len(params) == 2 {
-					dsn = fmt.Sprintf("%s://%s@%s%s", sentry.Scheme, token, sentry.Host, r.URL.Path)
-					log.Debugf("dsn: %s, url: %s", dsn, r.URL.Path)
-				} else if len(params) == 3 {
-					dsn = fmt.Sprintf("%s://%s@%s/%s", sentry.Scheme, token, sentry.Host, params[1])
-					env = params[2]
-					log.Debugf("dsn: %s, url: %s, env: %s", dsn, r.URL.Path, env)
-				} else {
-					log.Errorf("Unknown number of params in url string: %s, params: %v, len: %d", r.URL.Path, params, len(params))
-				}
-			}
+	clientIdleTimeout, err := cmd.Flags().GetDuration("client-idle-timeout")
+	if err != nil {
+		return err
+	}
+	if !cmd.Flags().Changed("client-idle-timeout") {
+		if envIT, err := time.ParseDuration(os.Getenv("SENTRY_GATEWAY_CLIENT_IDLE_TIMEOUT")); err == nil {
+			clientIdleTimeout = envIT
 		}
+	}
+
+	clients := newClientCache(newTransport, clientCacheSize, clientIdleTimeout)
 
-		wh := amtemplate.Data{}
-		decoder := json.NewDecoder(r.Body)
-		defer r.Body.Close()
+	var sp *spool
+	if spoolDir != "" {
+		log.Infof("Spooling alerts to %s", spoolDir)
 
-		err := decoder.Decode(&wh)
+		sp, err = newSpool(spoolDir)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Invalid webhook: %s\n", err)
-			return
+			return err
 		}
+	}
 
-		for _, alert := range wh.Alerts {
-			alert_env := env
-			if envLabel != "" {
-				e := getSentryEnvironmentFromAlert(alert, envLabel)
-				if e != "" {
-					alert_env = e
-					log.Infof("Extracted sentry env: %s from alert: %s", alert_env, alert.Labels["alertname"])
-				}
-			}
-			hookChan <- gatewayRequest{dsn, alert_env, alert}
-		}
+	h := newHealth(q, g.defaultDSN)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/-/healthy", h.handleHealthy)
+	mux.HandleFunc("/-/ready", h.handleReady)
+	mux.HandleFunc("/debug/clients", clients.handleDebug)
+	mux.Handle("/", &webhookHandler{
+		g:         g,
+		q:         q,
+		sp:        sp,
+		auth:      auth,
+		maxSize:   maxWebhookSize,
+		proxyURL:  proxyURL,
+		keySource: keySource,
 	})
 
 	s := &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+
+	p := &pool{
+		q:        q,
+		sp:       sp,
+		h:        h,
+		clients:  clients,
+		resolver: res,
+		builder:  g.builder,
+	}
+	p.start()
+
+	spooled, err := sp.load(g.root)
+	if err != nil {
+		return err
+	}
+	if len(spooled) > 0 {
+		log.Infof("Replaying %d spooled alerts", len(spooled))
+		for _, req := range spooled {
+			if err := q.push(req); err != nil {
+				return err
+			}
+		}
 	}
 
 	log.Info("Starting to listen on: ", addr)
 
 	go func() {
-		err := s.ListenAndServe()
+		var err error
+		if tlsConfig != nil {
+			// certificates come from the TLS config
+			err = s.ListenAndServeTLS("", "")
+		} else {
+			err = s.ListenAndServe()
+		}
 		if err != nil && err != http.ErrServerClosed {
 			fmt.Fprintf(os.Stderr, "Unable to start server: %s\n", err)
 			os.Exit(1)
 		}
 	}()
 
-	go worker(hookChan, t, fpTemplates, dumbTimestamps)
-
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
 	<-sigCh
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	log.Infof("Shutting down, waiting up to %s for %d queued alerts", shutdownTimeout, q.len())
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
 	err = s.Shutdown(ctx)
 	if err != nil {
-		return err
+		log.Errorf("Could not shut down the server cleanly: %s", err)
 	}
 
-	for len(hookChan) > 0 {
-		time.Sleep(1)
+	// no more webhooks can come in now
+	delivered, failed, _ := p.stats()
+	q.close()
+
+	err = p.wait(ctx)
+	if err != nil {
+		log.Errorf("Workers did not finish in time: %s", err)
+	}
+
+	deadline, _ := ctx.Deadline()
+	if !p.clients.flush(time.Until(deadline)) {
+		log.Error("Could not flush every Sentry client in time")
 	}
-	close(hookChan)
+
+	nowDelivered, nowFailed, busy := p.stats()
+	log.Infof("Shutdown complete: %d alerts delivered, %d failed, %d abandoned",
+		nowDelivered-delivered, nowFailed-failed, q.len()+busy)
 
 	return nil
 }
 
-func createTemplate(templateString string) (*template.Template, error) {
+// eventTemplate is implemented by both text/template and html/template.
+type eventTemplate interface {
+	Execute(wr io.Writer, data interface{}) error
+}
+
+// createTemplate parses a template with text/template, or with html/template
+// to have its output HTML-escaped.
+func createTemplate(templateString string, html bool) (eventTemplate, error) {
+	if html {
+		t := htmltemplate.New("").Option("missingkey=zero")
+		t.Funcs(htmltemplate.FuncMap(amtemplate.DefaultFuncs))
+		return t.Parse(templateString)
+	}
+
 	t := template.New("").Option("missingkey=zero")
 	t.Funcs(template.FuncMap(amtemplate.DefaultFuncs))
 	return t.Parse(templateString)
 }
 
+func createTemplates(templateStrings []string, html bool) ([]eventTemplate, error) {
+	var templates []eventTemplate
+	for _, templateString := range templateStrings {
+		t, err := createTemplate(templateString, html)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// createTemplateMap creates named templates.
+func createTemplateMap(templateStrings map[string]string, html bool) (map[string]eventTemplate, error) {
+	templates := map[string]eventTemplate{}
+	for name, templateString := range templateStrings {
+		t, err := createTemplate(templateString, html)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+// executeTemplateMap executes named templates, leaving out the failing ones
+// and those rendering to nothing.
+func executeTemplateMap(kind string, templates map[string]eventTemplate, data templateData) map[string]string {
+	values := map[string]string{}
+	for name, t := range templates {
+		var buf bytes.Buffer
+
+		err := t.Execute(&buf, data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid %s template %s: %s\n", kind, name, err)
+			templateErrors.WithLabelValues(kind).Inc()
+			continue
+		}
+
+		if buf.Len() > 0 {
+			values[name] = buf.String()
+		}
+	}
+	return values
+}
+
 func getEventTimestamp(alert amtemplate.Alert, dumb bool) time.Time {
 	if dumb {
 		return time.Now()
@@ -293,30 +609,8 @@ func getEventTimestamp(alert amtemplate.Alert, dumb bool) time.Time {
 	}[alert.Status])
 }
 
-func getEventTags(alert amtemplate.Alert) map[string]string {
-	tags := make(map[string]string)
-	for _, label := range alert.Labels.SortedPairs() {
-		tags[label.Name] = label.Value
-	}
-	return tags
-}
-
-func getEventAlertLevel(alert amtemplate.Alert) sentry.Level {
-	for _, label := range alert.Labels.SortedPairs() {
-		if label.Name == "severity" {
-			switch label.Value {
-			case "info":
-				return sentry.LevelInfo
-			case "warning":
-				return sentry.LevelWarning
-			case "error":
-				return sentry.LevelError
-			case "critical":
-				return sentry.LevelFatal
-			}
-		}
-	}
-	return sentry.LevelError
+func getEventAlertLevel(alert amtemplate.Alert, levels *levelMapper) sentry.Level {
+	return levels.level(alert)
 }
 
 func getSentryEnvironmentFromAlert(alert amtemplate.Alert, env_label string) string {
@@ -328,14 +622,15 @@ func getSentryEnvironmentFromAlert(alert amtemplate.Alert, env_label string) str
 	return ""
 }
 
-func getEventFingerprint(alert amtemplate.Alert, fingerprintTemplates []*template.Template) []string {
+func getEventFingerprint(data templateData, fingerprintTemplates []eventTemplate) []string {
 	var fingerprint []string
 	for _, fpTemplate := range fingerprintTemplates {
 		var fp bytes.Buffer
 
-		err := fpTemplate.Execute(&fp, alert)
+		err := fpTemplate.Execute(&fp, data)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Invalid fingerprint template: %s\n", err)
+			templateErrors.WithLabelValues("fingerprint").Inc()
 			continue
 		}
 
@@ -344,61 +639,20 @@ func getEventFingerprint(alert amtemplate.Alert, fingerprintTemplates []*templat
 	return fingerprint
 }
 
-func worker(
-	hookChan chan gatewayRequest,
-	t *template.Template,
-	fingerprintTemplates []*template.Template,
-	dumbTimestamps bool,
-) {
-	sentryClients := map[string]*sentry.Client{}
-
-	for req := range hookChan {
-		dsn, env, alert := req.dsn, req.env, req.alert
-
-		clientKey := dsn + env
-		client := sentryClients[clientKey]
-		if client == nil {
-			sentryOptions := sentry.ClientOptions{
-				Dsn:         dsn,
-				Environment: env}
-			if newClient, err := sentry.NewClient(sentryOptions); err == nil {
-				sentryClients[clientKey] = newClient
-				client = newClient
-			} else {
-				fmt.Fprintf(os.Stderr, "Could not init Sentry client: %s\n", err)
-				continue
-			}
-		}
-
-		var buf bytes.Buffer
-
-		err := t.Execute(&buf, alert)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Invalid template: %s\n", err)
-			continue
-		}
-
-		event := sentry.NewEvent()
-		event.Message = buf.String()
-		event.Timestamp = getEventTimestamp(alert, dumbTimestamps)
-		event.Extra["starts_at"] = alert.StartsAt
-		event.Extra["ends_at"] = alert.EndsAt
-		event.Logger = "alertmanager"
-		event.Tags = getEventTags(alert)
-		event.Level = getEventAlertLevel(alert)
-		event.Fingerprint = getEventFingerprint(alert, fingerprintTemplates)
-
-		eventID := client.CaptureEvent(event, nil, nil)
-		if eventID != nil {
-			log.Infof("event_id:%s alert_name:%s, level:%s, env: %s\n", *eventID, alert.Labels["alertname"], event.Level, env)
-		} else {
-			log.Errorf("Sentry capture event was dropped. alert_name:%s", alert.Labels["alertname"])
-		}
+func setupDebug(cmd *cobra.Command) error {
+	debug, err := cmd.Flags().GetBool("debug")
+	if err != nil {
+		return err
+	}
+	if debug {
+		log.Info("Enabling debug output")
+		log.SetLevel(log.DebugLevel)
 	}
+	return nil
 }
 
-func version() {
-	fmt.Printf("Version: %s (%s)\n", VERSION, COMMIT)
+func version(w io.Writer) {
+	fmt.Fprintf(w, "Version: %s (%s)\n", VERSION, COMMIT)
 }
 
 func init() {
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+func TestCreateTemplate(t *testing.T) {
+	data := templateData{
+		Alert: amtemplate.Alert{
+			Labels: amtemplate.KV{
+				"alertname": "HighLoad",
+				"query":     `rate(x[5m]) > 0.9 && y < 1`,
+			},
+			Annotations: amtemplate.KV{
+				"summary": `"db" & 'cache'`,
+				"link":    `<a href="http://grafana">dashboard</a>`,
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		text     string
+		html     string
+	}{
+		{
+			name:     "plain",
+			template: "{{ .Labels.alertname }}",
+			text:     "HighLoad",
+			html:     "HighLoad",
+		},
+		{
+			name:     "angle brackets and ampersands",
+			template: "{{ .Labels.query }}",
+			text:     "rate(x[5m]) > 0.9 && y < 1",
+			html:     "rate(x[5m]) &gt; 0.9 &amp;&amp; y &lt; 1",
+		},
+		{
+			name:     "quotes",
+			template: "{{ .Annotations.summary }}",
+			text:     `"db" & 'cache'`,
+			html:     "&#34;db&#34; &amp; &#39;cache&#39;",
+		},
+		{
+			name:     "literal text",
+			template: "<b>{{ .Labels.alertname }}</b>",
+			text:     "<b>HighLoad</b>",
+			html:     "<b>HighLoad</b>",
+		},
+		{
+			name:     "safeHtml",
+			template: "{{ .Annotations.link | safeHtml }}",
+			text:     `<a href="http://grafana">dashboard</a>`,
+			html:     `<a href="http://grafana">dashboard</a>`,
+		},
+		{
+			name:     "missing label",
+			template: "[{{ .Labels.missing }}]",
+			text:     "[]",
+			html:     "[]",
+		},
+		{
+			name:     "missing annotation",
+			template: "{{ if .Annotations.missing }}set{{ else }}unset{{ end }}",
+			text:     "unset",
+			html:     "unset",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, html := range []bool{false, true} {
+			want := tt.text
+			if html {
+				want = tt.html
+			}
+
+			tmpl, err := createTemplate(tt.template, html)
+			if err != nil {
+				t.Fatalf("%s (html %t): %s", tt.name, html, err)
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, data); err != nil {
+				t.Fatalf("%s (html %t): %s", tt.name, html, err)
+			}
+			if got := buf.String(); got != want {
+				t.Errorf("%s (html %t): got %q, want %q", tt.name, html, got, want)
+			}
+		}
+	}
+}
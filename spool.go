@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+const spoolExt = ".json"
+
+// spool is a write-ahead directory of accepted requests. A request is
+// written before the webhook is acknowledged and removed once Sentry has
+// received it, so whatever is left in there gets replayed on startup.
+//
+// A nil spool is valid and does nothing.
+type spool struct {
+	dir string
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+type spoolEntry struct {
+	DSN   string           `json:"dsn"`
+	Env   string           `json:"env"`
+	Route string           `json:"route"`
+	Alert amtemplate.Alert `json:"alert"`
+	Data  *groupData       `json:"data"`
+
+	Alerts []amtemplate.Alert `json:"alerts,omitempty"`
+}
+
+func newSpool(dir string) (*spool, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &spool{dir: dir}, nil
+}
+
+// write persists the request and records its spool file on it.
+func (s *spool) write(req *gatewayRequest) error {
+	if s == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(spoolEntry{req.dsn, req.env, req.route.id, req.alert, req.data, req.alerts})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.seq++
+	// names sort in the order the requests were accepted
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spoolExt)
+	s.mu.Unlock()
+
+	path := filepath.Join(s.dir, name)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	req.spoolFile = name
+	return nil
+}
+
+// remove deletes the spool file of a request that needs no more delivering.
+func (s *spool) remove(req gatewayRequest) {
+	if s == nil || req.spoolFile == "" {
+		return
+	}
+
+	err := os.Remove(filepath.Join(s.dir, req.spoolFile))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Could not remove spool file: %s\n", err)
+	}
+}
+
+// load reads back every spooled request in the order they were accepted.
+// Requests whose route is gone from the configuration fall back to root.
+func (s *spool) load(root *route) ([]gatewayRequest, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), spoolExt) {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var reqs []gatewayRequest
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var entry spoolEntry
+		err = json.Unmarshal(data, &entry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid spool file %s: %s\n", name, err)
+			continue
+		}
+
+		// spooled before group data was kept
+		if entry.Data == nil {
+			entry.Data = &groupData{}
+		}
+
+		rt := root.find(entry.Route)
+		if rt == nil {
+			rt = root
+		}
+
+		reqs = append(reqs, gatewayRequest{
+			dsn:       entry.DSN,
+			env:       entry.Env,
+			alert:     entry.Alert,
+			alerts:    entry.Alerts,
+			data:      entry.Data,
+			route:     rt,
+			spoolFile: name,
+		})
+	}
+
+	return reqs, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+func TestSpoolRoundTrip(t *testing.T) {
+	root := &route{id: "root"}
+	child := &route{id: "root/0"}
+	root.routes = []*route{child}
+
+	sp, err := newSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var written []gatewayRequest
+	for i, rt := range []*route{child, root, child} {
+		req := gatewayRequest{
+			dsn:   "https://key@sentry.example.com/1",
+			env:   "prod",
+			alert: amtemplate.Alert{Fingerprint: string(rune('a' + i)), Labels: amtemplate.KV{"alertname": "Test"}},
+			data:  &groupData{Receiver: "team"},
+			route: rt,
+		}
+		if err := sp.write(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.spoolFile == "" {
+			t.Fatal("spool file not recorded on the request")
+		}
+		written = append(written, req)
+	}
+
+	// routes gone from the configuration fall back to root
+	loaded, err := sp.load(&route{id: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(written) {
+		t.Fatalf("got %d requests, want %d", len(loaded), len(written))
+	}
+	for _, req := range loaded {
+		if req.route.id != "root" {
+			t.Errorf("got route %s for a missing route, want root", req.route.id)
+		}
+	}
+
+	loaded, err = sp.load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, req := range loaded {
+		want := written[i]
+		if req.alert.Fingerprint != want.alert.Fingerprint {
+			t.Errorf("request %d: got alert %s, want %s, out of order", i, req.alert.Fingerprint, want.alert.Fingerprint)
+		}
+		if req.dsn != want.dsn || req.env != want.env || req.route != want.route || req.spoolFile != want.spoolFile {
+			t.Errorf("request %d: got %s %s %s %s, want %s %s %s %s", i,
+				req.dsn, req.env, req.route.id, req.spoolFile, want.dsn, want.env, want.route.id, want.spoolFile)
+		}
+		if req.data.Receiver != "team" {
+			t.Errorf("request %d: group data lost", i)
+		}
+	}
+
+	sp.remove(written[1])
+	// removing twice is harmless
+	sp.remove(written[1])
+
+	loaded, err = sp.load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded[0].alert.Fingerprint != "a" || loaded[1].alert.Fingerprint != "c" {
+		t.Errorf("got %d requests after removing one, want a and c", len(loaded))
+	}
+}
+
+func TestSpoolLoadSkipsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := newSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := gatewayRequest{route: &route{id: "root"}, data: &groupData{}}
+	if err := sp.write(&req); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"00000000000000000000-000000.json": "{",
+		"leftover.json.tmp":                "{}",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := sp.load(&route{id: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].spoolFile != req.spoolFile {
+		t.Errorf("got %d requests, want only the valid one", len(loaded))
+	}
+}
+
+func TestNilSpool(t *testing.T) {
+	var sp *spool
+	req := gatewayRequest{route: &route{id: "root"}}
+	if err := sp.write(&req); err != nil {
+		t.Error(err)
+	}
+	sp.remove(req)
+	if reqs, err := sp.load(&route{id: "root"}); reqs != nil || err != nil {
+		t.Errorf("got %v, %v", reqs, err)
+	}
+}
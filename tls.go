@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certReloader serves the listener's key pair and client CAs from disk,
+// reloading them on handshakes after the files have changed.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu       sync.Mutex
+	modTimes [3]time.Time
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+}
+
+// newTLSConfig returns the listener configuration for the given files, the
+// client CA being optional.
+func newTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+
+	_, err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetCertificate:     r.getCertificate,
+		GetConfigForClient: r.getConfigForClient,
+	}, nil
+}
+
+// reload loads the files again if any of them has changed since the last
+// time, telling whether it did.
+func (r *certReloader) reload() (bool, error) {
+	var modTimes [3]time.Time
+	for i, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert != nil && modTimes == r.modTimes {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	var clientCA *x509.CertPool
+	if r.caFile != "" {
+		pem, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return false, err
+		}
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("no certificates found in %s", r.caFile)
+		}
+	}
+
+	r.modTimes = modTimes
+	r.cert = &cert
+	r.clientCA = clientCA
+	return true, nil
+}
+
+// current reloads the files if needed, keeping the previous ones when that
+// fails so that a botched rotation does not take the listener down.
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	reloaded, err := r.reload()
+	if err != nil {
+		log.Errorf("Could not reload TLS certificates, keeping the current ones: %s", err)
+	} else if reloaded {
+		log.Info("Reloaded TLS certificates")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cert, r.clientCA
+}
+
+func (r *certReloader) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := r.current()
+	if cert == nil {
+		return nil, errors.New("no TLS certificate loaded")
+	}
+	return cert, nil
+}
+
+func (r *certReloader) getConfigForClient(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+	cert, clientCA := r.current()
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+	}
+	if clientCA != nil {
+		config.ClientCAs = clientCA
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sentry "github.com/getsentry/sentry-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	retryMinBackoff = 1 * time.Second
+	retryMaxBackoff = 1 * time.Minute
+
+	defaultRateLimitWait = 60 * time.Second
+	transportTimeout     = 30 * time.Second
+)
+
+// permanentError is a delivery failure that retrying will not fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func isPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// rateLimitError is a delivery failure because Sentry rate limits the
+// project, not worth retrying before the limit is lifted.
+type rateLimitError struct {
+	until time.Time
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limited by Sentry until %s", e.until.Format(time.RFC3339))
+}
+
+// resultTransport is a synchronous sentry.Transport which keeps the
+// outcome of every event for the worker to collect.
+type resultTransport interface {
+	sentry.Transport
+	// result returns and forgets the outcome of the event.
+	result(id sentry.EventID) error
+	// close releases the resources of a transport no longer in use.
+	close()
+}
+
+// eventResults implements the result half of resultTransport.
+type eventResults struct {
+	mu      sync.Mutex
+	results map[sentry.EventID]error
+}
+
+func (r *eventResults) set(id sentry.EventID, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.results == nil {
+		r.results = map[sentry.EventID]error{}
+	}
+	r.results[id] = err
+}
+
+func (r *eventResults) result(id sentry.EventID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err, ok := r.results[id]
+	if !ok {
+		return errors.New("event was not sent")
+	}
+	delete(r.results, id)
+	return err
+}
+
+// retryTransport is a resultTransport which retries failed deliveries with
+// jittered exponential backoff.
+type retryTransport struct {
+	eventResults
+
+	dsn        *sentry.Dsn
+	client     *http.Client
+	maxRetries int
+
+	mu            sync.Mutex
+	disabledUntil time.Time
+}
+
+func newRetryTransport(maxRetries int) *retryTransport {
+	return &retryTransport{maxRetries: maxRetries}
+}
+
+func (t *retryTransport) Configure(options sentry.ClientOptions) {
+	dsn, err := sentry.NewDsn(options.Dsn)
+	if err != nil {
+		log.Errorf("Invalid Sentry DSN: %s", err)
+		return
+	}
+	t.dsn = dsn
+	t.client = &http.Client{Timeout: transportTimeout}
+}
+
+func (t *retryTransport) SendEvent(event *sentry.Event) {
+	t.set(event.EventID, t.send(event))
+}
+
+// Flush is a no-op, events are sent before SendEvent returns.
+func (t *retryTransport) Flush(_ time.Duration) bool {
+	return true
+}
+
+func (t *retryTransport) close() {
+	if t.client != nil {
+		t.client.CloseIdleConnections()
+	}
+}
+
+func (t *retryTransport) send(event *sentry.Event) error {
+	if t.dsn == nil {
+		return &permanentError{errors.New("no valid DSN configured")}
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= t.maxRetries; attempt++ {
+		// rather than holding up the worker, and every other DSN of its
+		// shard, while the project is rate limited
+		t.mu.Lock()
+		until := t.disabledUntil
+		t.mu.Unlock()
+		if time.Now().Before(until) {
+			return &rateLimitError{until}
+		}
+
+		if attempt > 0 {
+			time.Sleep(backoff(attempt, retryMinBackoff, retryMaxBackoff))
+		}
+
+		var retryable bool
+		retryable, lastErr = t.post(body)
+		if lastErr == nil {
+			return nil
+		}
+		if !retryable {
+			return &permanentError{lastErr}
+		}
+
+		log.Debugf("Sending event %s failed (attempt %d): %s", event.EventID, attempt+1, lastErr)
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %s", t.maxRetries+1, lastErr)
+}
+
+// post makes a single delivery attempt, telling whether a failure is worth
+// retrying.
+func (t *retryTransport) post(body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, t.dsn.StoreAPIURL().String(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for headerKey, headerValue := range t.dsn.RequestHeaders() {
+		request.Header.Set(headerKey, headerValue)
+	}
+
+	response, err := t.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+
+	if until, ok := rateLimitedUntil(time.Now(), response); ok {
+		t.mu.Lock()
+		if until.After(t.disabledUntil) {
+			t.disabledUntil = until
+		}
+		t.mu.Unlock()
+	}
+
+	switch {
+	case response.StatusCode < 300:
+		return false, nil
+	case response.StatusCode == http.StatusTooManyRequests:
+		return true, errors.New("rate limited by Sentry")
+	case response.StatusCode >= 500:
+		return true, fmt.Errorf("Sentry responded with %s", response.Status)
+	default:
+		return false, fmt.Errorf("Sentry rejected the event with %s", response.Status)
+	}
+}
+
+// backoff returns the jittered delay before the given retry attempt, which
+// doubles from min with every attempt up to max.
+func backoff(attempt int, min, max time.Duration) time.Duration {
+	d := max
+	if attempt < 16 {
+		if exp := min << uint(attempt-1); exp < d {
+			d = exp
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// rateLimitedUntil reads the X-Sentry-Rate-Limits and Retry-After headers
+// of a response, the former taking precedence.
+func rateLimitedUntil(now time.Time, r *http.Response) (time.Time, bool) {
+	if header := r.Header.Get("X-Sentry-Rate-Limits"); header != "" {
+		var wait time.Duration
+		for _, limit := range strings.Split(header, ",") {
+			parts := strings.Split(strings.TrimSpace(limit), ":")
+
+			seconds, err := strconv.ParseFloat(parts[0], 64)
+			if err != nil {
+				continue
+			}
+
+			// events are in the "error" category, an empty list means all
+			applies := len(parts) < 2 || parts[1] == ""
+			if !applies {
+				for _, category := range strings.Split(parts[1], ";") {
+					if category == "error" || category == "default" {
+						applies = true
+					}
+				}
+			}
+
+			if d := time.Duration(seconds * float64(time.Second)); applies && d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			return now.Add(wait), true
+		}
+	}
+
+	if r.StatusCode != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+
+	header := r.Header.Get("Retry-After")
+	if date, err := http.ParseTime(header); err == nil {
+		return date, true
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	return now.Add(defaultRateLimitWait), true
+}
+
+// sink is where dry-run transports write events to, one JSON per line.
+type sink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// sinkTransport is a resultTransport which writes events to a sink instead
+// of sending them.
+type sinkTransport struct {
+	eventResults
+
+	sink *sink
+}
+
+func (t *sinkTransport) Configure(_ sentry.ClientOptions) {}
+
+func (t *sinkTransport) SendEvent(event *sentry.Event) {
+	data, err := json.Marshal(event)
+	if err == nil {
+		t.sink.mu.Lock()
+		_, err = t.sink.w.Write(append(data, '\n'))
+		t.sink.mu.Unlock()
+	}
+	t.set(event.EventID, err)
+}
+
+func (t *sinkTransport) Flush(_ time.Duration) bool {
+	return true
+}
+
+func (t *sinkTransport) close() {}
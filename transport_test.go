@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sentry "github.com/getsentry/sentry-go"
+)
+
+func TestRateLimitedUntil(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		status     int
+		rateLimits string
+		retryAfter string
+		wait       time.Duration
+		limited    bool
+	}{
+		{name: "success", status: 200},
+		{name: "server error", status: 503, retryAfter: "10"},
+		{name: "all categories", status: 200, rateLimits: "30::organization", wait: 30 * time.Second, limited: true},
+		{name: "empty category list", status: 429, rateLimits: "30:", wait: 30 * time.Second, limited: true},
+		{name: "error category", status: 429, rateLimits: "30:transaction;error:key", wait: 30 * time.Second, limited: true},
+		{name: "default category", status: 429, rateLimits: "30:default", wait: 30 * time.Second, limited: true},
+		{name: "other categories", status: 200, rateLimits: "30:transaction;session"},
+		{name: "longest applicable limit", status: 429, rateLimits: "30:error, 90:transaction, 60:", wait: 60 * time.Second, limited: true},
+		{name: "fractional seconds", status: 429, rateLimits: "1.5:error", wait: 1500 * time.Millisecond, limited: true},
+		{name: "invalid limit skipped", status: 429, rateLimits: "soon:error, 10:error", wait: 10 * time.Second, limited: true},
+		{name: "rate limits before retry-after", status: 429, rateLimits: "30:error", retryAfter: "120", wait: 30 * time.Second, limited: true},
+		{name: "inapplicable rate limits", status: 429, rateLimits: "30:transaction", retryAfter: "120", wait: 120 * time.Second, limited: true},
+		{name: "retry-after seconds", status: 429, retryAfter: "45", wait: 45 * time.Second, limited: true},
+		{name: "retry-after date", status: 429, retryAfter: "Wed, 01 Jan 2020 00:02:00 GMT", wait: 2 * time.Minute, limited: true},
+		{name: "invalid retry-after", status: 429, retryAfter: "later", wait: defaultRateLimitWait, limited: true},
+		{name: "no retry-after", status: 429, wait: defaultRateLimitWait, limited: true},
+	}
+
+	for _, tt := range tests {
+		r := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		if tt.rateLimits != "" {
+			r.Header.Set("X-Sentry-Rate-Limits", tt.rateLimits)
+		}
+		if tt.retryAfter != "" {
+			r.Header.Set("Retry-After", tt.retryAfter)
+		}
+
+		until, limited := rateLimitedUntil(now, r)
+		if limited != tt.limited {
+			t.Errorf("%s: got limited %t, want %t", tt.name, limited, tt.limited)
+			continue
+		}
+		if limited && !until.Equal(now.Add(tt.wait)) {
+			t.Errorf("%s: got limited for %s, want %s", tt.name, until.Sub(now), tt.wait)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 1 * time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},
+		{16, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			d := backoff(tt.attempt, time.Second, time.Minute)
+			if d < tt.max/2 || d > tt.max {
+				t.Errorf("attempt %d: got %s, want between %s and %s", tt.attempt, d, tt.max/2, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestRetryTransportRateLimited(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	transport := newRetryTransport(5)
+	transport.Configure(sentry.ClientOptions{Dsn: strings.Replace(server.URL, "://", "://key@", 1) + "/1"})
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		err := transport.send(&sentry.Event{EventID: "1"})
+
+		var rl *rateLimitError
+		if !errors.As(err, &rl) {
+			t.Fatalf("send %d: got %v, want a rate limit error", i, err)
+		}
+		if isPermanent(err) {
+			t.Errorf("send %d: rate limit error is permanent", i)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("send %d: took %s", i, elapsed)
+		}
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+const (
+	// webhookVersion is the version of the webhook payload understood
+	webhookVersion = "4"
+
+	defaultMaxWebhookSize = 10 << 20
+)
+
+// webhookMessage is the payload of Alertmanager's webhook notifier.
+type webhookMessage struct {
+	amtemplate.Data
+
+	Version  string `json:"version"`
+	GroupKey string `json:"groupKey"`
+}
+
+// groupData is the part of a webhook shared by all of its alerts, attached
+// to their events as the alertmanager context.
+type groupData struct {
+	Receiver          string        `json:"receiver"`
+	Status            string        `json:"status"`
+	GroupKey          string        `json:"group_key,omitempty"`
+	GroupLabels       amtemplate.KV `json:"group_labels"`
+	CommonLabels      amtemplate.KV `json:"common_labels"`
+	CommonAnnotations amtemplate.KV `json:"common_annotations"`
+	ExternalURL       string        `json:"external_url"`
+}
+
+func (m *webhookMessage) groupData() *groupData {
+	return &groupData{
+		Receiver:          m.Receiver,
+		Status:            m.Status,
+		GroupKey:          m.GroupKey,
+		GroupLabels:       m.GroupLabels,
+		CommonLabels:      m.CommonLabels,
+		CommonAnnotations: m.CommonAnnotations,
+		ExternalURL:       m.ExternalURL,
+	}
+}
+
+// templateData is what templates are executed with: the alert, along with
+// the data of its group as .Data. Group templates also get the alerts of the
+// notification as .Alerts.
+type templateData struct {
+	amtemplate.Alert
+
+	Data   *groupData
+	Alerts amtemplate.Alerts
+}
+
+// decodeWebhook parses a webhook payload. A missing version is accepted for
+// hand-written payloads.
+func decodeWebhook(body []byte) (*webhookMessage, error) {
+	msg := &webhookMessage{}
+	err := json.Unmarshal(body, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.Version != "" && msg.Version != webhookVersion {
+		return nil, fmt.Errorf("unsupported webhook version %q, expected %q", msg.Version, webhookVersion)
+	}
+	return msg, nil
+}
+
+// webhookError replies to a webhook with the error as JSON.
+func webhookError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
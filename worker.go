@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxRequeues is how many times a request failing transiently is
+	// queued again, which with the backoff below spans two to three hours.
+	maxRequeues       = 20
+	requeueMinBackoff = 30 * time.Second
+	requeueMaxBackoff = 10 * time.Minute
+)
+
+// pool sends queued requests to Sentry, running one worker per queue shard.
+type pool struct {
+	// counters are first to keep them 64-bit aligned for atomic access
+	delivered int64
+	failed    int64
+	busy      int64
+
+	q       *queue
+	sp      *spool
+	h       *health
+	clients *clientCache
+	// resolver, when set, resolves issues instead of sending resolved events
+	resolver *resolver
+	builder  *eventBuilder
+
+	wg sync.WaitGroup
+}
+
+func (p *pool) start() {
+	for i := range p.q.shards {
+		p.wg.Add(1)
+		go p.work(i)
+	}
+}
+
+// wait blocks until every worker has returned or the context is done.
+func (p *pool) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stats returns how many requests were delivered and failed so far, and
+// how many are being processed right now.
+func (p *pool) stats() (int, int, int) {
+	return int(atomic.LoadInt64(&p.delivered)), int(atomic.LoadInt64(&p.failed)), int(atomic.LoadInt64(&p.busy))
+}
+
+func (p *pool) work(shard int) {
+	defer p.wg.Done()
+
+	for {
+		req, ok := p.q.get(shard)
+		if !ok {
+			return
+		}
+		atomic.AddInt64(&p.busy, 1)
+		if p.process(req) {
+			atomic.AddInt64(&p.delivered, 1)
+		} else {
+			atomic.AddInt64(&p.failed, 1)
+		}
+		atomic.AddInt64(&p.busy, -1)
+	}
+}
+
+// process sends a single request, telling whether it was delivered.
+func (p *pool) process(req gatewayRequest) bool {
+	dsn, env, alert := req.dsn, req.env, req.alert
+
+	if p.resolver != nil && alert.Status == "resolved" {
+		return p.resolve(req)
+	}
+
+	client, err := p.clients.get(dsn, env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not init Sentry client: %s\n", err)
+		eventsDropped.WithLabelValues(dsnProject(dsn), env).Inc()
+		p.sp.remove(req)
+		return false
+	}
+
+	event, err := p.builder.build(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid template: %s\n", err)
+		eventsDropped.WithLabelValues(dsnProject(dsn), env).Inc()
+		p.sp.remove(req)
+		return false
+	}
+
+	project := dsnProject(dsn)
+	start := time.Now()
+
+	eventID := client.CaptureEvent(event, nil, nil)
+	if eventID == nil {
+		log.Errorf("Sentry capture event was dropped. alert_name:%s", alert.Labels["alertname"])
+		eventsDropped.WithLabelValues(project, env).Inc()
+		p.sp.remove(req)
+		return false
+	}
+
+	err = client.Transport.(resultTransport).result(*eventID)
+	sendDuration.WithLabelValues(project, env).Observe(time.Since(start).Seconds())
+	p.h.record(dsn, err)
+
+	switch {
+	case err == nil:
+		log.Infof("event_id:%s alert_name:%s, level:%s, env: %s\n", *eventID, alert.Labels["alertname"], event.Level, env)
+		eventsSent.WithLabelValues(project, env).Inc()
+		p.sp.remove(req)
+	case isPermanent(err):
+		log.Errorf("Sentry rejected event. event_id:%s alert_name:%s, error: %s", *eventID, alert.Labels["alertname"], err)
+		eventsDropped.WithLabelValues(project, env).Inc()
+		p.sp.remove(req)
+	default:
+		log.Errorf("Could not send event. event_id:%s alert_name:%s, error: %s", *eventID, alert.Labels["alertname"], err)
+		if !p.retry(req, err) {
+			eventsDropped.WithLabelValues(project, env).Inc()
+		}
+	}
+
+	return err == nil
+}
+
+// resolve resolves the Sentry issues of a resolved alert.
+func (p *pool) resolve(req gatewayRequest) bool {
+	alert := req.alert
+
+	n, err := p.resolver.resolve(req.dsn, alert.Fingerprint)
+	switch {
+	case err == nil:
+		log.Infof("Resolved %d issues. alert_name:%s, fingerprint:%s", n, alert.Labels["alertname"], alert.Fingerprint)
+		issuesResolved.WithLabelValues(dsnProject(req.dsn)).Add(float64(n))
+		p.sp.remove(req)
+	case isPermanent(err):
+		log.Errorf("Could not resolve issues. alert_name:%s, error: %s", alert.Labels["alertname"], err)
+		p.sp.remove(req)
+	default:
+		log.Errorf("Could not resolve issues. alert_name:%s, error: %s", alert.Labels["alertname"], err)
+		p.retry(req, err)
+	}
+
+	return err == nil
+}
+
+// retry queues a request that failed transiently again after a backoff, or
+// after the rate limit of its project is lifted, telling whether it did.
+// Requests retried too often, or once the queue is closed, stay spooled to
+// be replayed on the next start.
+func (p *pool) retry(req gatewayRequest, err error) bool {
+	if req.retries >= maxRequeues {
+		log.Errorf("Giving up on alert %s after %d retries", req.alert.Labels["alertname"], req.retries)
+		return false
+	}
+
+	delay := backoff(req.retries+1, requeueMinBackoff, requeueMaxBackoff)
+	var rl *rateLimitError
+	if errors.As(err, &rl) {
+		if wait := time.Until(rl.until); wait > delay {
+			delay = wait
+		}
+	}
+
+	req.retries++
+	if p.q.retry(req, delay) != nil {
+		return false
+	}
+	log.Infof("Retrying alert %s in %s", req.alert.Labels["alertname"], delay.Round(time.Second))
+	return true
+}